@@ -4,9 +4,11 @@ go 1.17
 
 require (
 	github.com/TheFireMike/go-mrt v0.0.0-20220205210421-b3040c1c0b7e
+	github.com/klauspost/compress v1.15.15
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.26.0
 	github.com/stretchr/testify v1.7.0
+	github.com/ulikunitz/xz v0.5.15
 )
 
 require (
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"github.com/TheFireMike/moasDetector/parser"
+	"github.com/TheFireMike/moasDetector/rir"
 	"github.com/TheFireMike/moasDetector/routes"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -10,13 +11,42 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
-var dir = flag.String("dir", "", "input file directory (required)")
+var dir = flag.String("dir", "", "input file directory, or - to read one MRT stream from stdin (required)")
 var output = flag.String("output", ".", "output directory")
 var peers = flag.String("peers", "", "peers to process announcements from (comma separated list of ASNs) (default all)")
 var maxCPUs = flag.Int("max-cpus", 0, "limit the number of used CPUs (default 0 => no limit)")
+var workers = flag.Int("workers", 0, "limit the number of concurrently processed files (default 0 => max-cpus or the number of CPUs)")
 var ignore = flag.String("ignore", "", "ignore files whose path matches this regex")
+var onError = flag.String("on-error", "abort", "error policy: abort (no results are written if a file fails) or continue (the error is recorded in the statistics of the file)")
+var snapshot = flag.String("snapshot", "", "select the table dump closest to this time per collector (RFC 3339) (default all table dumps)")
+var snapshotRange = flag.String("snapshot-range", "", "select all table dumps in this time range (two RFC 3339 times separated by a comma)")
+var snapshotTolerance = flag.Duration("snapshot-tolerance", 8*time.Hour, "flag selected table dumps which are further away from the snapshot time")
+var batch = flag.Bool("batch", false, "group the input files by time into snapshots and detect the MOAS prefixes of every snapshot separately")
+var batchInterval = flag.Duration("batch-interval", 8*time.Hour, "time interval of a snapshot in batch mode")
+var maxSkew = flag.Duration("max-skew", 0, "warn if the table dumps are further apart or update files start further away from them (default 0 => no check)")
+var refuseSkew = flag.Bool("refuse-skew", false, "abort instead of warning if the input files exceed max-skew")
+var replay = flag.Bool("replay", false, "apply the updates in timestamp order on top of the table dumps and detect MOAS prefixes in the resulting routing table")
+var reservedPrefixes = flag.String("reserved-prefixes", "", "files with the reserved prefixes, e.g. the IANA special-purpose address registries as CSV (comma separated list of files) (default built-in registries)")
+var reservedASNs = flag.String("reserved-asns", "", "files with the reserved ASNs, e.g. the IANA special-purpose AS number registry as CSV (comma separated list of files) (default built-in registry)")
+var allowPrefixes = flag.String("allow-prefixes", "", "files with prefixes which are processed even if they are reserved (comma separated list of files)")
+var denyPrefixes = flag.String("deny-prefixes", "", "files with prefixes which are never processed (comma separated list of files)")
+var allowASNs = flag.String("allow-asns", "", "files with origin ASNs which are processed even if they are reserved (comma separated list of files)")
+var denyASNs = flag.String("deny-asns", "", "files with origin ASNs which are never processed (comma separated list of files)")
+var delegations = flag.String("delegations", "", "RIR delegated-extended files to annotate the allocations of the MOAS prefixes and origin ASNs and to report unallocated routes (comma separated list of files)")
+var ipv4MinLength = flag.Int("ipv4-min-length", 0, "skip IPv4 prefixes which are shorter (default 0 => no limit)")
+var ipv4MaxLength = flag.Int("ipv4-max-length", 0, "skip IPv4 prefixes which are longer (default 0 => no limit)")
+var ipv6MinLength = flag.Int("ipv6-min-length", 0, "skip IPv6 prefixes which are shorter (default 0 => no limit)")
+var ipv6MaxLength = flag.Int("ipv6-max-length", 0, "skip IPv6 prefixes which are longer (default 0 => no limit)")
+var tooSpecific = flag.Bool("too-specific", false, "detect the MOAS prefixes of the unicast prefixes which are longer than ipv4-max-length or ipv6-max-length separately instead of skipping them")
+var verbose = flag.Bool("verbose", false, "add the AS path, next hop, origin and communities of the route of every peer to the MOAS prefixes")
+var replayUntil = flag.String("replay-until", "", "stop the replay at this time (RFC 3339, implies -replay) (default all updates)")
+
+var replayUntilTime time.Time
+var snapshots parser.SnapshotSelection
+var delegationRecords *rir.Delegations
 
 func init() {
 	log.Logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
@@ -37,14 +67,85 @@ func init() {
 
 	log.Logger = zerolog.New(zerolog.MultiLevelWriter(zerolog.ConsoleWriter{Out: os.Stderr}, logfile)).With().Timestamp().Logger()
 
+	if *onError != "abort" && *onError != "continue" {
+		log.Fatal().Msg("flag 'on-error' is invalid")
+	}
+
+	if *batch && *dir == "-" {
+		log.Fatal().Msg("flag 'batch' can not be used with stdin")
+	}
+	if *batch && *snapshot != "" {
+		log.Fatal().Msg("flags 'batch' and 'snapshot' can not be combined")
+	}
+	if *batchInterval <= 0 {
+		log.Fatal().Msg("flag 'batch-interval' is invalid")
+	}
+
+	snapshots.Tolerance = *snapshotTolerance
+	if *snapshot != "" && *snapshotRange != "" {
+		log.Fatal().Msg("flags 'snapshot' and 'snapshot-range' can not be combined")
+	}
+	if *snapshot != "" {
+		snapshots.Target, err = time.Parse(time.RFC3339, *snapshot)
+		if err != nil {
+			log.Fatal().Err(err).Msg("flag 'snapshot' is invalid")
+		}
+	}
+	if *snapshotRange != "" {
+		times := strings.Split(*snapshotRange, ",")
+		if len(times) != 2 {
+			log.Fatal().Msg("flag 'snapshot-range' is invalid")
+		}
+		snapshots.From, err = time.Parse(time.RFC3339, times[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("flag 'snapshot-range' is invalid")
+		}
+		snapshots.To, err = time.Parse(time.RFC3339, times[1])
+		if err != nil {
+			log.Fatal().Err(err).Msg("flag 'snapshot-range' is invalid")
+		}
+	}
+
+	if *replayUntil != "" {
+		*replay = true
+		replayUntilTime, err = time.Parse(time.RFC3339, *replayUntil)
+		if err != nil {
+			log.Fatal().Err(err).Msg("flag 'replay-until' is invalid")
+		}
+	}
+
+	if *ipv4MinLength < 0 || *ipv4MinLength > 32 {
+		log.Fatal().Msg("flag 'ipv4-min-length' is invalid")
+	}
+	if *ipv4MaxLength < 0 || *ipv4MaxLength > 32 || (*ipv4MaxLength > 0 && *ipv4MaxLength < *ipv4MinLength) {
+		log.Fatal().Msg("flag 'ipv4-max-length' is invalid")
+	}
+	if *ipv6MinLength < 0 || *ipv6MinLength > 128 {
+		log.Fatal().Msg("flag 'ipv6-min-length' is invalid")
+	}
+	if *ipv6MaxLength < 0 || *ipv6MaxLength > 128 || (*ipv6MaxLength > 0 && *ipv6MaxLength < *ipv6MinLength) {
+		log.Fatal().Msg("flag 'ipv6-max-length' is invalid")
+	}
+	if *tooSpecific && *ipv4MaxLength == 0 && *ipv6MaxLength == 0 {
+		log.Fatal().Msg("flag 'too-specific' requires 'ipv4-max-length' or 'ipv6-max-length'")
+	}
+
+	if *delegations != "" {
+		delegationRecords, err = rir.Load(splitList(*delegations))
+		if err != nil {
+			log.Fatal().Err(err).Msg("flag 'delegations' is invalid")
+		}
+	}
+
 	if *maxCPUs != 0 {
 		runtime.GOMAXPROCS(*maxCPUs)
 	}
+	if *workers == 0 {
+		*workers = runtime.GOMAXPROCS(0)
+	}
 }
 
 func main() {
-	channels := routes.NewChannels()
-
 	var p []string
 	if *peers != "" {
 		p = strings.Split(*peers, ",")
@@ -55,15 +156,119 @@ func main() {
 		i = ignore
 	}
 
-	go parser.ProcessFiles(*dir, channels, p, i)
+	filterFiles := parser.FilterFiles{
+		ReservedPrefixes: splitList(*reservedPrefixes),
+		ReservedASNs:     splitList(*reservedASNs),
+		AllowPrefixes:    splitList(*allowPrefixes),
+		AllowASNs:        splitList(*allowASNs),
+		DenyPrefixes:     splitList(*denyPrefixes),
+		DenyASNs:         splitList(*denyASNs),
+	}
+	var filter *parser.Filter
+	if len(filterFiles.ReservedPrefixes)+len(filterFiles.ReservedASNs)+len(filterFiles.AllowPrefixes)+
+		len(filterFiles.AllowASNs)+len(filterFiles.DenyPrefixes)+len(filterFiles.DenyASNs) > 0 {
+		var err error
+		filter, err = parser.LoadFilter(filterFiles)
+		if err != nil {
+			log.Fatal().Err(err).Msg("loading filter failed")
+		}
+	}
+
+	prefixLengths := parser.PrefixLengths{
+		IPv4Min: *ipv4MinLength,
+		IPv4Max: *ipv4MaxLength,
+		IPv6Min: *ipv6MinLength,
+		IPv6Max: *ipv6MaxLength,
+	}
+
+	config := parser.Config{
+		Peers:           p,
+		IgnoreRegex:     i,
+		Replay:          *replay,
+		ReplayUntil:     replayUntilTime,
+		Workers:         *workers,
+		Snapshots:       snapshots,
+		MaxSkew:         *maxSkew,
+		RefuseSkew:      *refuseSkew,
+		Filter:          filter,
+		PrefixLengths:   prefixLengths,
+		TooSpecific:     *tooSpecific,
+		RouteAttributes: *verbose,
+		ContinueOnError: *onError == "continue",
+	}
+
+	if *batch {
+		processBatch(config)
+		return
+	}
+
+	detectMOAS(*output, func(channels routes.Channels) {
+		if *dir == "-" {
+			parser.ProcessStream(os.Stdin, "stdin", channels, config)
+		} else {
+			parser.ProcessFiles(*dir, channels, config)
+		}
+	})
+}
+
+// splitList splits a comma separated flag value.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// processBatch detects the MOAS prefixes of every snapshot in the input directory and writes the results to a
+// subdirectory per snapshot.
+func processBatch(config parser.Config) {
+	snapshotList, skipped, err := parser.GroupSnapshots(*dir, config, *batchInterval)
+	if err != nil {
+		log.Fatal().Err(err).Msg("grouping snapshots failed")
+	}
+
+	index := routes.SnapshotIndex{
+		Skipped: skipped,
+	}
+	for _, snapshot := range snapshotList {
+		snapshot := snapshot
+		directory := snapshot.Time.Format("20060102.1504")
+		err = os.MkdirAll(filepath.Join(*output, directory), os.ModePerm)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create directory")
+		}
+
+		log.Info().Time("snapshot", snapshot.Time).Int("files", len(snapshot.Files())).Msg("processing snapshot")
+		detectMOAS(filepath.Join(*output, directory), func(channels routes.Channels) {
+			parser.ProcessSnapshot(snapshot, channels, config)
+		})
+
+		index.Snapshots = append(index.Snapshots, routes.SnapshotIndexEntry{
+			Time:      snapshot.Time,
+			Directory: directory,
+			Files:     snapshot.Files(),
+		})
+	}
+
+	err = routes.PrintSnapshotIndex(index, *output)
+	if err != nil {
+		log.Fatal().Err(err).Msg("printing snapshot index failed")
+	}
+}
+
+// detectMOAS runs process, which has to close the channels when it is done, and writes the detected MOAS prefixes to
+// the output directory.
+func detectMOAS(output string, process func(channels routes.Channels)) {
+	channels := routes.NewChannels(runtime.GOMAXPROCS(0))
+	go process(channels)
 
-	r := routes.NewRoutes()
+	r := routes.NewRoutes(*replay, delegationRecords, *tooSpecific)
 	err := r.HandleAnnouncements(channels)
 	if err != nil {
 		log.Fatal().Err(err).Msg("handling route announcements failed")
 	}
 
-	err = r.PrintMOASPrefixes(*output)
+	err = r.PrintMOASPrefixes(output)
 	if err != nil {
 		log.Fatal().Err(err).Msg("printing moas failed")
 	}
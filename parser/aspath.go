@@ -0,0 +1,203 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+)
+
+// BGP path attribute type codes of the AS paths
+const (
+	bgpAttributeASPath  = 2
+	bgpAttributeAS4Path = 17
+)
+
+// AS path segment types of BGP confederations (RFC 5065), which are not defined by go-mrt
+const (
+	asPathSegmentConfedSequence mrt.BGPASPathSegmentType = 3
+	asPathSegmentConfedSet      mrt.BGPASPathSegmentType = 4
+)
+
+// asTrans is the placeholder of 4-byte ASNs in the AS_PATH of sessions with 2-byte ASNs (RFC 6793)
+const asTrans = "23456"
+
+// getASPath returns the effective AS path of a route. If the route was received over a session with 2-byte ASNs, so
+// that AS_PATH contains AS_TRANS, the real ASNs are taken from AS4_PATH (RFC 6793), which is reported by the second
+// return value. The AS4_PATH of routes received over a session with 4-byte ASNs has to be removed before, see
+// withoutAS4Path.
+func getASPath(attributes []*mrt.BGPPathAttribute) (mrt.BGPPathAttributeASPath, bool, bool) {
+	var asPath, as4Path mrt.BGPPathAttributeASPath
+	var hasASPath, hasAS4Path bool
+	for _, attribute := range attributes {
+		value, ok := attribute.Value.(mrt.BGPPathAttributeASPath)
+		if !ok {
+			continue
+		}
+		switch attribute.TypeCode {
+		case bgpAttributeASPath:
+			asPath, hasASPath = value, true
+		case bgpAttributeAS4Path:
+			as4Path, hasAS4Path = value, true
+		}
+	}
+
+	if !hasASPath {
+		return nil, false, false
+	}
+	if !hasAS4Path || !containsASTrans(asPath) {
+		return asPath, false, true
+	}
+
+	merged, ok := mergeAS4Path(asPath, as4Path)
+	if !ok {
+		return asPath, false, true
+	}
+	return merged, true, true
+}
+
+// containsASTrans returns true if an AS of the AS path is AS_TRANS.
+func containsASTrans(asPath mrt.BGPPathAttributeASPath) bool {
+	for _, segment := range asPath {
+		for _, asn := range segment.Value {
+			if asn.String() == asTrans {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutAS4Path returns the attributes without AS4_PATH, which is ignored for routes received over a session with
+// 4-byte ASNs (RFC 6793, section 4.1), even if AS_PATH contains AS_TRANS.
+func withoutAS4Path(attributes []*mrt.BGPPathAttribute) []*mrt.BGPPathAttribute {
+	filtered := make([]*mrt.BGPPathAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		if attribute.TypeCode != bgpAttributeAS4Path {
+			filtered = append(filtered, attribute)
+		}
+	}
+	return filtered
+}
+
+// mergeAS4Path reconstructs the AS path from AS_PATH and AS4_PATH as described in RFC 6793, section 4.2.3: the
+// leading ASes of AS_PATH which are missing in AS4_PATH are prepended to AS4_PATH. It returns false if AS4_PATH is
+// longer than AS_PATH, in which case AS4_PATH has to be ignored.
+func mergeAS4Path(asPath, as4Path mrt.BGPPathAttributeASPath) (mrt.BGPPathAttributeASPath, bool) {
+	// confederation segments in AS4_PATH are discarded
+	var as4Segments mrt.BGPPathAttributeASPath
+	for _, segment := range as4Path {
+		if segment.Type != asPathSegmentConfedSequence && segment.Type != asPathSegmentConfedSet {
+			as4Segments = append(as4Segments, segment)
+		}
+	}
+
+	leading := getASPathLength(asPath) - getASPathLength(as4Segments)
+	if leading < 0 {
+		return nil, false
+	}
+
+	var merged mrt.BGPPathAttributeASPath
+	for _, segment := range asPath {
+		if segment.Type == asPathSegmentConfedSequence || segment.Type == asPathSegmentConfedSet {
+			// confederation segments are not counted, they are kept if they precede the missing ASes
+			merged = append(merged, segment)
+			continue
+		}
+		if leading == 0 {
+			break
+		}
+		if segment.Type == mrt.BGPASPathSegmentTypeASSet {
+			merged = append(merged, segment)
+			leading--
+			continue
+		}
+		n := len(segment.Value)
+		if n > leading {
+			n = leading
+		}
+		merged = append(merged, &mrt.BGPASPathSegment{Type: segment.Type, Value: segment.Value[:n]})
+		leading -= n
+	}
+
+	for _, segment := range as4Segments {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].Type == mrt.BGPASPathSegmentTypeASSequence && segment.Type == mrt.BGPASPathSegmentTypeASSequence {
+			// adjacent sequences are joined, so the origin is the last AS of the last segment
+			value := append(append([]mrt.AS{}, merged[last].Value...), segment.Value...)
+			merged[last] = &mrt.BGPASPathSegment{Type: segment.Type, Value: value}
+			continue
+		}
+		merged = append(merged, segment)
+	}
+	return merged, true
+}
+
+// getASPathLength returns the length of an AS path as used for route selection: an AS set counts as one AS and
+// confederation segments are not counted.
+func getASPathLength(asPath mrt.BGPPathAttributeASPath) int {
+	length := 0
+	for _, segment := range asPath {
+		switch segment.Type {
+		case mrt.BGPASPathSegmentTypeASSequence:
+			length += len(segment.Value)
+		case mrt.BGPASPathSegmentTypeASSet:
+			length++
+		}
+	}
+	return length
+}
+
+// stripASPath removes the confederation segments (RFC 5065) and the segments without ASes from the AS path, so the
+// last segment contains the origin. The shape of the AS path is counted in statistics. It returns false if the AS path
+// contains an unknown segment type.
+func stripASPath(asPath mrt.BGPPathAttributeASPath, statistics *routes.ASPathStatistics) (mrt.BGPPathAttributeASPath, bool) {
+	var stripped mrt.BGPPathAttributeASPath
+	var confederation, emptySegments bool
+	for _, segment := range asPath {
+		switch segment.Type {
+		case asPathSegmentConfedSequence, asPathSegmentConfedSet:
+			confederation = true
+		case mrt.BGPASPathSegmentTypeASSequence, mrt.BGPASPathSegmentTypeASSet:
+			if len(segment.Value) == 0 {
+				emptySegments = true
+			} else {
+				stripped = append(stripped, segment)
+			}
+		default:
+			statistics.UnknownSegment++
+			return nil, false
+		}
+	}
+
+	if confederation {
+		statistics.Confederation++
+	}
+	if emptySegments {
+		statistics.EmptySegments++
+	}
+	for i := 0; i < len(stripped)-1; i++ {
+		if stripped[i].Type == mrt.BGPASPathSegmentTypeASSet {
+			statistics.InnerASSet++
+			break
+		}
+	}
+	return stripped, true
+}
+
+// getPathOriginAS returns the origin AS of the AS path without checking it, e.g. "1103" or "{1103,3333}". It is empty
+// if the AS path is missing or has no AS outside of confederation segments. The AS path statistics are not updated.
+func getPathOriginAS(attributes []*mrt.BGPPathAttribute) string {
+	asPath, _, ok := getASPath(attributes)
+	if !ok {
+		return ""
+	}
+	asPath, ok = stripASPath(asPath, &routes.ASPathStatistics{})
+	if !ok || len(asPath) == 0 {
+		return ""
+	}
+
+	lastASPathEntry := asPath[len(asPath)-1]
+	if lastASPathEntry.Type == mrt.BGPASPathSegmentTypeASSequence {
+		return lastASPathEntry.Value[len(lastASPathEntry.Value)-1].String()
+	}
+	return formatASPath(mrt.BGPPathAttributeASPath{lastASPathEntry})
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func as2(asn uint16) mrt.AS {
+	return mrt.AS{byte(asn >> 8), byte(asn)}
+}
+
+func as4(asn uint32) mrt.AS {
+	return mrt.AS{byte(asn >> 24), byte(asn >> 16), byte(asn >> 8), byte(asn)}
+}
+
+func asSequence(ases ...mrt.AS) *mrt.BGPASPathSegment {
+	return &mrt.BGPASPathSegment{Type: mrt.BGPASPathSegmentTypeASSequence, Value: ases}
+}
+
+func TestGetASPath_AS4Path(t *testing.T) {
+	attributes := []*mrt.BGPPathAttribute{
+		{TypeCode: bgpAttributeASPath, Value: mrt.BGPPathAttributeASPath{asSequence(as2(3333), as2(23456), as2(23456))}},
+		{TypeCode: bgpAttributeAS4Path, Value: mrt.BGPPathAttributeASPath{asSequence(as4(196608), as4(200000))}},
+	}
+
+	asPath, reconstructed, ok := getASPath(attributes)
+	assert.True(t, ok)
+	assert.True(t, reconstructed)
+	assert.Equal(t, "3333 196608 200000", formatASPath(asPath))
+
+	f := &mrtFile{}
+	originAS, _, ok := f.getOriginAS(attributes, net.IPNet{IP: net.IPv4(192, 0, 2, 0), Mask: net.CIDRMask(24, 32)})
+	assert.True(t, ok)
+	assert.Equal(t, "200000", originAS)
+	assert.Equal(t, 1, f.statistics.ReconstructedASPaths)
+}
+
+func TestGetASPath_AS4PathTooLong(t *testing.T) {
+	attributes := []*mrt.BGPPathAttribute{
+		{TypeCode: bgpAttributeASPath, Value: mrt.BGPPathAttributeASPath{asSequence(as2(3333), as2(1103))}},
+		{TypeCode: bgpAttributeAS4Path, Value: mrt.BGPPathAttributeASPath{asSequence(as4(3333), as4(1103), as4(200000))}},
+	}
+
+	asPath, reconstructed, ok := getASPath(attributes)
+	assert.True(t, ok)
+	assert.False(t, reconstructed)
+	assert.Equal(t, "3333 1103", formatASPath(asPath))
+}
+
+func TestGetASPath_WithoutASTrans(t *testing.T) {
+	attributes := []*mrt.BGPPathAttribute{
+		{TypeCode: bgpAttributeASPath, Value: mrt.BGPPathAttributeASPath{asSequence(as4(3333), as4(196608), as4(1103))}},
+		{TypeCode: bgpAttributeAS4Path, Value: mrt.BGPPathAttributeASPath{asSequence(as4(196608), as4(200000))}},
+	}
+
+	asPath, reconstructed, ok := getASPath(attributes)
+	assert.True(t, ok)
+	assert.False(t, reconstructed)
+	assert.Equal(t, "3333 196608 1103", formatASPath(asPath))
+}
+
+func TestGetASPath_ASSet(t *testing.T) {
+	attributes := []*mrt.BGPPathAttribute{
+		{TypeCode: bgpAttributeASPath, Value: mrt.BGPPathAttributeASPath{
+			asSequence(as2(3333), as2(23456)),
+			{Type: mrt.BGPASPathSegmentTypeASSet, Value: []mrt.AS{as2(23456), as2(1103)}},
+		}},
+		{TypeCode: bgpAttributeAS4Path, Value: mrt.BGPPathAttributeASPath{
+			asSequence(as4(196608)),
+			{Type: mrt.BGPASPathSegmentTypeASSet, Value: []mrt.AS{as4(200000), as4(1103)}},
+		}},
+	}
+
+	asPath, reconstructed, ok := getASPath(attributes)
+	assert.True(t, ok)
+	assert.True(t, reconstructed)
+	assert.Equal(t, "3333 196608 {200000,1103}", formatASPath(asPath))
+}
+
+func TestGetOriginAS_PathShapes(t *testing.T) {
+	prefix := net.IPNet{IP: net.IPv4(192, 0, 2, 0), Mask: net.CIDRMask(24, 32)}
+	asPath := func(segments ...*mrt.BGPASPathSegment) []*mrt.BGPPathAttribute {
+		return []*mrt.BGPPathAttribute{{TypeCode: bgpAttributeASPath, Value: mrt.BGPPathAttributeASPath(segments)}}
+	}
+	confedSequence := &mrt.BGPASPathSegment{Type: asPathSegmentConfedSequence, Value: []mrt.AS{as4(64512), as4(64513)}}
+	asSet := &mrt.BGPASPathSegment{Type: mrt.BGPASPathSegmentTypeASSet, Value: []mrt.AS{as4(1103), as4(3333)}}
+
+	f := &mrtFile{}
+	originAS, _, ok := f.getOriginAS(asPath(confedSequence, asSequence(as4(3333), as4(1103))), prefix)
+	assert.True(t, ok)
+	assert.Equal(t, "1103", originAS)
+
+	// the trailing confederation segment does not hide the origin
+	originAS, _, ok = f.getOriginAS(asPath(asSequence(as4(3333), as4(1103)), confedSequence), prefix)
+	assert.True(t, ok)
+	assert.Equal(t, "1103", originAS)
+
+	originAS, _, ok = f.getOriginAS(asPath(asSequence(as4(174)), asSet, asSequence()), prefix)
+	assert.True(t, ok)
+	assert.Equal(t, "{1103,3333}", originAS)
+
+	originAS, _, ok = f.getOriginAS(asPath(asSequence(as4(174)), asSet, asSequence(as4(1103))), prefix)
+	assert.True(t, ok)
+	assert.Equal(t, "1103", originAS)
+
+	_, reason, ok := f.getOriginAS(asPath(confedSequence), prefix)
+	assert.False(t, ok)
+	assert.Equal(t, routes.FilterReasonEmptyASPath, reason)
+
+	_, reason, ok = f.getOriginAS(asPath(&mrt.BGPASPathSegment{Type: 5, Value: []mrt.AS{as4(1103)}}), prefix)
+	assert.False(t, ok)
+	assert.Equal(t, routes.FilterReasonUnknownSegment, reason)
+
+	_, reason, ok = f.getOriginAS(nil, prefix)
+	assert.False(t, ok)
+	assert.Equal(t, routes.FilterReasonMissingASPath, reason)
+
+	assert.Equal(t, routes.ASPathStatistics{
+		Sequence:       3,
+		TrailingASSet:  1,
+		Empty:          1,
+		UnknownSegment: 1,
+		Missing:        1,
+		Confederation:  3,
+		InnerASSet:     1,
+		EmptySegments:  1,
+	}, f.statistics.ASPaths)
+}
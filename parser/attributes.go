@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"net"
+	"strings"
+)
+
+// BGP path attribute type codes which are not decoded into a specific type by go-mrt or which are converted before
+// decoding
+const (
+	bgpAttributeNextHop       = 3
+	bgpAttributeMPReachNLRI   = 14
+	bgpAttributeMPUnreachNLRI = 15
+)
+
+// bgpAttributeFlagExtendedLength is the flag of path attributes with a 2-byte length.
+const bgpAttributeFlagExtendedLength = 0x10
+
+// bgpMessageTypeUpdate is the type of BGP update messages.
+const bgpMessageTypeUpdate = 2
+
+// getRouteAttributes collects the attributes of a route which are needed to understand how the peer reached the
+// origin. The next hop is taken from MP_REACH_NLRI if multiprotocol is set, otherwise from NEXT_HOP, each falling back
+// to the other. It returns nil if the attributes are not requested.
+func (f *mrtFile) getRouteAttributes(attributes []*mrt.BGPPathAttribute, multiprotocol bool) *routes.RouteAttributes {
+	if !f.routeAttributes {
+		return nil
+	}
+
+	routeAttributes := &routes.RouteAttributes{}
+	var nextHop, mpNextHop net.IP
+	for _, attribute := range attributes {
+		switch value := attribute.Value.(type) {
+		case mrt.BGPPathAttributeOrigin:
+			routeAttributes.Origin = formatOrigin(value)
+		case mrt.BGPPathAttributeCommunities:
+			for _, community := range value {
+				routeAttributes.Communities = append(routeAttributes.Communities,
+					fmt.Sprintf("%d:%d", uint32(community)>>16, uint32(community)&0xffff))
+			}
+		case mrt.BGPPathAttributeLargeCommunities:
+			for _, community := range value {
+				routeAttributes.LargeCommunities = append(routeAttributes.LargeCommunities, fmt.Sprintf("%d:%d:%d",
+					binary.BigEndian.Uint32(community[0:4]),
+					binary.BigEndian.Uint32(community[4:8]),
+					binary.BigEndian.Uint32(community[8:12])))
+			}
+		case *mrt.BGPPathAttributeMPReachNLRI:
+			mpNextHop = value.NextHop
+		case net.IP:
+			if attribute.TypeCode == bgpAttributeNextHop {
+				nextHop = value
+			}
+		case []byte:
+			// TABLE_DUMP_V2 RIB entries contain an abbreviated MP_REACH_NLRI attribute with only the next hop (RFC 6396)
+			if attribute.TypeCode == bgpAttributeMPReachNLRI {
+				mpNextHop = parseAbbreviatedNextHop(value)
+			}
+		}
+	}
+
+	if asPath, _, ok := getASPath(attributes); ok {
+		routeAttributes.ASPath = formatASPath(asPath)
+	}
+	if multiprotocol && mpNextHop != nil || nextHop == nil {
+		nextHop = mpNextHop
+	}
+	if nextHop != nil {
+		routeAttributes.NextHop = nextHop.String()
+	}
+	return routeAttributes
+}
+
+// parseAbbreviatedNextHop returns the next hop of an abbreviated MP_REACH_NLRI attribute. If a link-local address
+// follows the global IPv6 address, only the global address is returned.
+func parseAbbreviatedNextHop(value []byte) net.IP {
+	if len(value) == 0 || len(value) < 1+int(value[0]) {
+		return nil
+	}
+	switch value[0] {
+	case net.IPv4len:
+		return net.IP(value[1 : 1+net.IPv4len])
+	case net.IPv6len, 2 * net.IPv6len:
+		return net.IP(value[1 : 1+net.IPv6len])
+	}
+	return nil
+}
+
+func formatOrigin(origin mrt.BGPPathAttributeOrigin) string {
+	switch origin {
+	case mrt.BGPPathAttributeOriginIGP:
+		return "IGP"
+	case mrt.BGPPathAttributeOriginEGP:
+		return "EGP"
+	case mrt.BGPPathAttributeOriginIncomplete:
+		return "INCOMPLETE"
+	}
+	return fmt.Sprintf("%d", origin)
+}
+
+// formatASPath returns the AS path separated by spaces, AS sets are enclosed in curly braces, e.g. "3333 1103 {1,2}".
+// Confederation sequences are enclosed in parentheses and confederation sets in square brackets.
+func formatASPath(asPath mrt.BGPPathAttributeASPath) string {
+	var segments []string
+	for _, segment := range asPath {
+		ases := make([]string, 0, len(segment.Value))
+		for _, as := range segment.Value {
+			ases = append(ases, as.String())
+		}
+		switch segment.Type {
+		case mrt.BGPASPathSegmentTypeASSequence:
+			segments = append(segments, ases...)
+		case mrt.BGPASPathSegmentTypeASSet:
+			segments = append(segments, "{"+strings.Join(ases, ",")+"}")
+		case asPathSegmentConfedSequence:
+			segments = append(segments, "("+strings.Join(ases, " ")+")")
+		case asPathSegmentConfedSet:
+			segments = append(segments, "["+strings.Join(ases, ",")+"]")
+		default:
+			segments = append(segments, fmt.Sprintf("<%d:%s>", segment.Type, strings.Join(ases, " ")))
+		}
+	}
+	return strings.Join(segments, " ")
+}
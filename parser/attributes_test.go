@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestGetRouteAttributes_RIBEntry(t *testing.T) {
+	f := &mrtFile{routeAttributes: true}
+	attributes := []*mrt.BGPPathAttribute{
+		{TypeCode: 1, Value: mrt.BGPPathAttributeOriginIGP},
+		{TypeCode: 2, Value: mrt.BGPPathAttributeASPath{
+			{Type: mrt.BGPASPathSegmentTypeASSequence, Value: []mrt.AS{{0, 0, 0x0d, 0x05}, {0, 0, 0x04, 0x4f}}},
+			{Type: mrt.BGPASPathSegmentTypeASSet, Value: []mrt.AS{{0, 0, 0, 1}, {0, 0, 0, 2}}},
+		}},
+		{TypeCode: 8, Value: mrt.BGPPathAttributeCommunities{0x0d050064}},
+		{TypeCode: 32, Value: mrt.BGPPathAttributeLargeCommunities{{0, 0, 0x0d, 0x05, 0, 0, 0, 1, 0, 0, 0, 2}}},
+		// abbreviated MP_REACH_NLRI with a global and a link-local next hop
+		{TypeCode: 14, Value: append([]byte{32}, append(net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1")...)...)},
+	}
+
+	assert.Equal(t, &routes.RouteAttributes{
+		ASPath:           "3333 1103 {1,2}",
+		NextHop:          "2001:db8::1",
+		Origin:           "IGP",
+		Communities:      []string{"3333:100"},
+		LargeCommunities: []string{"3333:1:2"},
+	}, f.getRouteAttributes(attributes, true))
+}
+
+func TestGetRouteAttributes_NextHop(t *testing.T) {
+	f := &mrtFile{routeAttributes: true}
+	attributes := []*mrt.BGPPathAttribute{
+		{TypeCode: 3, Value: net.ParseIP("192.0.2.1").To4()},
+		{TypeCode: 14, Value: &mrt.BGPPathAttributeMPReachNLRI{NextHop: net.ParseIP("2001:db8::1")}},
+	}
+
+	assert.Equal(t, "192.0.2.1", f.getRouteAttributes(attributes, false).NextHop)
+	assert.Equal(t, "2001:db8::1", f.getRouteAttributes(attributes, true).NextHop)
+	assert.Equal(t, "192.0.2.1", f.getRouteAttributes(attributes[:1], true).NextHop)
+}
+
+func TestGetRouteAttributes_Disabled(t *testing.T) {
+	f := &mrtFile{}
+	assert.Nil(t, f.getRouteAttributes(nil, false))
+}
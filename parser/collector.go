@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/rs/zerolog/log"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// collectorDirectory matches the directory names of the RIS (e.g. "rrc00") and RouteViews (e.g. "route-views2" or
+// "route-views.sydney") route collectors.
+var collectorDirectory = regexp.MustCompile(`^(rrc\d+|route-views\d*(\.[a-z0-9-]+)?)$`)
+
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// getCollectorFromPath returns the name of the route collector from the directory layout of RIS or RouteViews, or an
+// empty string if the path does not contain a collector directory. Archives named after a collector are accepted as
+// well. The innermost collector directory is used.
+func getCollectorFromPath(name string) string {
+	segments := strings.Split(filepath.ToSlash(name), "/")
+	// the last segment is the file itself
+	for i := len(segments) - 2; i >= 0; i-- {
+		segment := strings.ToLower(segments[i])
+		for _, extension := range archiveExtensions {
+			segment = strings.TrimSuffix(segment, extension)
+		}
+		if collectorDirectory.MatchString(segment) {
+			return segment
+		}
+	}
+	return ""
+}
+
+// getCollectorFromPeerIndexTable returns the name of the route collector from the collector BGP ID and the view name
+// of the peer index table, e.g. "192.0.2.1" or "192.0.2.1/view".
+func getCollectorFromPeerIndexTable(peers *mrt.TableDumpV2PeerIndexTable) string {
+	collector := peers.CollectorBGPID.String()
+	if peers.ViewName != "" {
+		collector += "/" + peers.ViewName
+	}
+	return collector
+}
+
+// assignCollectors names the collector of the files without a collector in their path. Table dumps are named after
+// their peer index table, and update files after the table dumps in the same directory if these belong to a single
+// collector. Otherwise, the collector of the update files stays unknown, which is logged, as their peers can not be
+// told apart from the peers of other collectors.
+func assignCollectors(files []*mrtFile) {
+	dumpCollectors := make(map[string]map[string]struct{})
+	var updates []*mrtFile
+	for _, f := range files {
+		if f.collector != "" {
+			continue
+		}
+		fileType, _, collector, _ := f.getFileTime()
+		switch {
+		case fileType == fileTypeDump && collector != "":
+			f.setCollector(collector)
+			directory := filepath.Dir(f.name)
+			if dumpCollectors[directory] == nil {
+				dumpCollectors[directory] = make(map[string]struct{})
+			}
+			dumpCollectors[directory][collector] = struct{}{}
+		case fileType == fileTypeUpdates:
+			updates = append(updates, f)
+		}
+	}
+
+	unknown := make(map[string]struct{})
+	for _, f := range updates {
+		directory := filepath.Dir(f.name)
+		collectors := dumpCollectors[directory]
+		if len(collectors) == 1 {
+			for collector := range collectors {
+				f.setCollector(collector)
+			}
+			continue
+		}
+		if _, ok := unknown[directory]; !ok {
+			unknown[directory] = struct{}{}
+			log.Warn().Str("directory", directory).Int("collectors", len(collectors)).
+				Msg("collector of the update files is unknown, their peers are not told apart from the peers of other collectors")
+		}
+	}
+}
+
+// setCollector sets the collector of the file and its peers.
+func (f *mrtFile) setCollector(collector string) {
+	f.collector = collector
+	f.statistics.Collector = collector
+}
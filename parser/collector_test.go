@@ -0,0 +1,27 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetCollectorFromPath_RIS(t *testing.T) {
+	assert.Equal(t, "rrc00", getCollectorFromPath("data/rrc00/2022.02/bview.20220205.0000.gz"))
+	assert.Equal(t, "rrc21", getCollectorFromPath("rrc21/updates.20220205.0000.gz"))
+}
+
+func TestGetCollectorFromPath_RouteViews(t *testing.T) {
+	assert.Equal(t, "route-views2", getCollectorFromPath("route-views2/bgpdata/2022.02/RIBS/rib.20220205.1400.bz2"))
+	assert.Equal(t, "route-views.sydney", getCollectorFromPath("data/route-views.sydney/bgpdata/2022.02/UPDATES/updates.20220205.1400.bz2"))
+}
+
+func TestGetCollectorFromPath_Archive(t *testing.T) {
+	assert.Equal(t, "rrc01", getCollectorFromPath("archives/rrc01.tar.gz/bview.20220205.0000.gz"))
+	assert.Equal(t, "rrc03", getCollectorFromPath("archives/rrc01.tar/rrc03/bview.20220205.0000.gz"))
+}
+
+func TestGetCollectorFromPath_Unknown(t *testing.T) {
+	assert.Equal(t, "", getCollectorFromPath("data/2022.02/bview.20220205.0000.gz"))
+	assert.Equal(t, "", getCollectorFromPath("rrc00"))
+	assert.Equal(t, "", getCollectorFromPath("data/rrc/bview.gz"))
+}
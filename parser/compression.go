@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+)
+
+const (
+	formatGzip    = "gzip"
+	formatBzip2   = "bzip2"
+	formatXz      = "xz"
+	formatZstd    = "zstd"
+	formatMRT     = "mrt"
+	formatUnknown = routes.FileFormatUnknown
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicXz    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress detects the format of the content by its first bytes and returns a reader for the decompressed MRT
+// records, the detected format and a function which releases the resources of the decompressor.
+func decompress(r io.Reader) (io.Reader, string, func(), error) {
+	br := bufio.NewReader(r)
+	// errors are ignored here, as a file which is too short is reported as unknown format
+	magic, _ := br.Peek(mrtHeaderLength)
+
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		content, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, formatGzip, nil, err
+		}
+		return content, formatGzip, func() { _ = content.Close() }, nil
+	case bytes.HasPrefix(magic, magicBzip2):
+		return bzip2.NewReader(br), formatBzip2, func() {}, nil
+	case bytes.HasPrefix(magic, magicXz):
+		content, err := xz.NewReader(br)
+		if err != nil {
+			return nil, formatXz, nil, err
+		}
+		return content, formatXz, func() {}, nil
+	case bytes.HasPrefix(magic, magicZstd):
+		content, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, formatZstd, nil, err
+		}
+		return content, formatZstd, content.Close, nil
+	case isMRTHeader(magic):
+		return br, formatMRT, func() {}, nil
+	default:
+		return nil, formatUnknown, nil, errUnknownFileType
+	}
+}
+
+// isMRTHeader checks whether the data starts with the header of an MRT record of a known type.
+func isMRTHeader(data []byte) bool {
+	if len(data) < mrtHeaderLength {
+		return false
+	}
+
+	switch mrt.RecordType(binary.BigEndian.Uint16(data[4:])) {
+	case mrt.TYPE_OSPFv2,
+		mrt.TYPE_TABLE_DUMP,
+		mrt.TYPE_TABLE_DUMP_V2,
+		mrt.TYPE_BGP4MP,
+		mrt.TYPE_BGP4MP_ET,
+		mrt.TYPE_ISIS,
+		mrt.TYPE_ISIS_ET,
+		mrt.TYPE_OSPFv3,
+		mrt.TYPE_OSPFv3_ET:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+// bzip2Records is "MRT records" compressed with bzip2, as the standard library has no bzip2 encoder.
+var bzip2Records = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x71, 0xc1,
+	0x42, 0xb5, 0x00, 0x00, 0x00, 0x97, 0x80, 0x40, 0x00, 0x00, 0x02, 0x14,
+	0x00, 0x0e, 0x00, 0x98, 0x00, 0x20, 0x00, 0x22, 0x06, 0x81, 0xea, 0x10,
+	0x03, 0x04, 0x2a, 0xd1, 0xb4, 0xe3, 0xc5, 0xdc, 0x91, 0x4e, 0x14, 0x24,
+	0x1c, 0x70, 0x50, 0xad, 0x40,
+}
+
+func compress(t *testing.T, data []byte, newWriter func(w io.Writer) (io.WriteCloser, error)) []byte {
+	var b bytes.Buffer
+	w, err := newWriter(&b)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	records := []byte("MRT records")
+	files := map[string][]byte{
+		formatGzip: compress(t, records, func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}),
+		formatBzip2: bzip2Records,
+		formatXz: compress(t, records, func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		}),
+		formatZstd: compress(t, records, func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}),
+	}
+
+	for expected, data := range files {
+		content, format, closeDecompress, err := decompress(bytes.NewReader(data))
+		if assert.NoError(t, err, expected) {
+			assert.Equal(t, expected, format)
+			decompressed, err := io.ReadAll(content)
+			assert.NoError(t, err, expected)
+			assert.Equal(t, records, decompressed, expected)
+			closeDecompress()
+		}
+	}
+
+	// uncompressed files are detected by the header of their first record
+	content, format, _, err := decompress(bytes.NewReader(emptyPeerIndexTable()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, formatMRT, format)
+		decompressed, err := io.ReadAll(content)
+		assert.NoError(t, err)
+		assert.Equal(t, emptyPeerIndexTable(), decompressed)
+	}
+}
+
+func TestDecompress_Errors(t *testing.T) {
+	corrupt := func(magic []byte) []byte {
+		return append(append([]byte{}, magic...), bytes.Repeat([]byte{0xff}, 20)...)
+	}
+
+	// files which are too short or start with an unknown record type are of an unknown format
+	for _, data := range [][]byte{nil, []byte("BZ"), []byte("not an MRT file"), mrtRecord(99, 0, nil)} {
+		_, format, _, err := decompress(bytes.NewReader(data))
+		assert.ErrorIs(t, err, errUnknownFileType)
+		assert.Equal(t, formatUnknown, format)
+	}
+
+	// the headers of gzip and xz are checked when the file is opened
+	_, format, _, err := decompress(bytes.NewReader(corrupt(magicGzip)))
+	assert.Error(t, err)
+	assert.Equal(t, formatGzip, format)
+
+	_, format, _, err = decompress(bytes.NewReader(corrupt(magicXz)))
+	assert.Error(t, err)
+	assert.Equal(t, formatXz, format)
+
+	// bzip2 and zstd only fail when the content is read
+	for expected, data := range map[string][]byte{formatBzip2: corrupt(magicBzip2), formatZstd: corrupt(magicZstd)} {
+		content, format, closeDecompress, err := decompress(bytes.NewReader(data))
+		if assert.NoError(t, err, expected) {
+			assert.Equal(t, expected, format)
+			_, err = io.ReadAll(content)
+			assert.Error(t, err, expected)
+			closeDecompress()
+		}
+	}
+}
+
+func TestProcessFS_Formats(t *testing.T) {
+	directory := detectMOASInFS(t, fstest.MapFS{
+		"rrc00/bview.20200913.1200.gz":  {Data: []byte{0x1f, 0x8b, 0xff, 0xff}},
+		"rrc00/bview.20200913.1200.zst": {Data: append(append([]byte{}, magicZstd...), 0xff, 0xff, 0xff, 0xff)},
+		"rrc00/README":                  {Data: []byte("not an MRT file")},
+	}, Config{ContinueOnError: true})
+
+	var statistics routes.Statistics
+	readOutput(t, directory, "statistics.json", &statistics)
+	files := make(map[string]routes.FileStatistics)
+	for _, file := range statistics.Files {
+		files[file.Name] = file
+	}
+
+	// a corrupt gzip header fails the file, a corrupt zstd frame marks the file as truncated and files of an unknown
+	// format are only listed
+	if assert.Contains(t, files, "rrc00/bview.20200913.1200.gz") {
+		assert.Equal(t, formatGzip, files["rrc00/bview.20200913.1200.gz"].Format)
+		assert.NotEmpty(t, files["rrc00/bview.20200913.1200.gz"].Error)
+	}
+	if assert.Contains(t, files, "rrc00/bview.20200913.1200.zst") {
+		assert.Equal(t, formatZstd, files["rrc00/bview.20200913.1200.zst"].Format)
+		assert.Empty(t, files["rrc00/bview.20200913.1200.zst"].Error)
+		assert.True(t, files["rrc00/bview.20200913.1200.zst"].Truncated)
+	}
+	if assert.Contains(t, files, "rrc00/README") {
+		assert.Equal(t, formatUnknown, files["rrc00/README"].Format)
+		assert.Empty(t, files["rrc00/README"].Error)
+	}
+	assert.Equal(t, 1, statistics.FailedFiles)
+	assert.Equal(t, 1, statistics.TruncatedFiles)
+	assert.Equal(t, 1, statistics.UnknownFormatFiles)
+}
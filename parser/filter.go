@@ -1,11 +1,53 @@
 package parser
 
 import (
+	"github.com/TheFireMike/moasDetector/routes"
 	"github.com/pkg/errors"
 	"net"
 )
 
+// Errors of reserved and denied prefixes and ASNs, from which the reasons of the filtered routes are derived. All other
+// errors of the prefix checks are caused by invalid prefixes.
+var (
+	errPrefixReserved = errors.New("prefix is reserved")
+	errPrefixDenied   = errors.New("prefix is denied")
+	errASNReserved    = errors.New("ASN is not public")
+	errASNDenied      = errors.New("ASN is denied")
+
+	errPrefixTooShort    = errors.New("prefix is shorter than the minimum length")
+	errPrefixTooSpecific = errors.New("prefix is longer than the maximum length")
+)
+
+// filterReason returns the reason of a filtered route for an error of the prefix and ASN checks.
+func filterReason(err error) string {
+	switch err {
+	case errPrefixReserved:
+		return routes.FilterReasonReservedPrefix
+	case errPrefixDenied:
+		return routes.FilterReasonDeniedPrefix
+	case errASNReserved:
+		return routes.FilterReasonReservedASN
+	case errASNDenied:
+		return routes.FilterReasonDeniedASN
+	case errPrefixTooShort:
+		return routes.FilterReasonTooShortPrefix
+	case errPrefixTooSpecific:
+		return routes.FilterReasonTooSpecificPrefix
+	default:
+		return routes.FilterReasonInvalidPrefix
+	}
+}
+
 func filterPrefix(prefix net.IPNet) error {
+	err := validatePrefix(prefix)
+	if err != nil {
+		return err
+	}
+	return filterReservedPrefix(prefix)
+}
+
+// validatePrefix filters prefixes which are not usable regardless of the registries.
+func validatePrefix(prefix net.IPNet) error {
 	// filter submasks with zero size
 	if size, _ := prefix.Mask.Size(); size == 0 {
 		return errors.New("prefix size of 0")
@@ -15,6 +57,38 @@ func filterPrefix(prefix net.IPNet) error {
 		return errors.New("prefix contains bits not in mask")
 	}
 
+	return nil
+}
+
+// PrefixLengths limits the lengths of the processed prefixes per address family. A limit of 0 is not checked.
+type PrefixLengths struct {
+	IPv4Min int
+	IPv4Max int
+	IPv6Min int
+	IPv6Max int
+}
+
+// check returns an error if the prefix is shorter than the minimum or longer than the maximum length of its address
+// family.
+func (l PrefixLengths) check(prefix net.IPNet) error {
+	minLength, maxLength := l.IPv6Min, l.IPv6Max
+	if prefix.IP.To4() != nil {
+		minLength, maxLength = l.IPv4Min, l.IPv4Max
+	}
+
+	length, _ := prefix.Mask.Size()
+	if minLength > 0 && length < minLength {
+		return errPrefixTooShort
+	}
+	if maxLength > 0 && length > maxLength {
+		return errPrefixTooSpecific
+	}
+	return nil
+}
+
+// filterReservedPrefix filters prefixes of the IANA special-purpose address registries which are not globally
+// reachable.
+func filterReservedPrefix(prefix net.IPNet) error {
 	if prefix.IP.To4() != nil {
 		// https://www.iana.org/assignments/iana-ipv4-special-registry/iana-ipv4-special-registry.xhtml
 		if prefix.IP[0] == 0 || // "This network"
@@ -31,7 +105,7 @@ func filterPrefix(prefix net.IPNet) error {
 			(prefix.IP[0] == 203 && prefix.IP[1] == 0 && prefix.IP[2] == 113) || // Documentation (TEST-NET-3)
 			(prefix.IP[0]&0xF0 == 240) || // Reserved
 			(prefix.IP.Equal(net.IPv4bcast)) {
-			return errors.New("prefix is reserved")
+			return errPrefixReserved
 		}
 	} else {
 		// https://www.iana.org/assignments/iana-ipv6-special-registry/iana-ipv6-special-registry.xhtml
@@ -50,7 +124,7 @@ func filterPrefix(prefix net.IPNet) error {
 			(prefix.IP[:4].Equal(net.IP{0x20, 0x01, 0x0d, 0xb8})) || // Documentation
 			prefix.IP.IsPrivate() || // Unique-Local
 			prefix.IP.IsLinkLocalUnicast() {
-			return errors.New("prefix is reserved")
+			return errPrefixReserved
 		}
 	}
 
@@ -68,7 +142,7 @@ func filterASN(asn int) error {
 		(asn >= 65536 && asn <= 65551) || // For documentation and sample code
 		(asn >= 4200000000 && asn <= 4294967294) || // For private use
 		asn == 4294967295 { // Reserved by [RFC7300]
-		return errors.New("ASN is not public")
+		return errASNReserved
 	}
 
 	return nil
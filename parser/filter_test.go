@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"github.com/TheFireMike/go-mrt"
 	"github.com/stretchr/testify/assert"
 	"net"
 	"testing"
@@ -244,3 +245,37 @@ func TestFilterPrefix_IPv6_LinkLocalUnicast(t *testing.T) {
 	_, prefix, _ = net.ParseCIDR("fec0::/10")
 	assert.Nil(t, filterPrefix(*prefix))
 }
+
+func TestPrefixLengths(t *testing.T) {
+	lengths := PrefixLengths{IPv4Min: 8, IPv4Max: 24, IPv6Max: 48}
+
+	_, prefix, _ := net.ParseCIDR("11.0.0.0/7")
+	assert.Equal(t, errPrefixTooShort, lengths.check(*prefix))
+	_, prefix, _ = net.ParseCIDR("11.0.0.0/8")
+	assert.Nil(t, lengths.check(*prefix))
+	_, prefix, _ = net.ParseCIDR("11.0.0.0/24")
+	assert.Nil(t, lengths.check(*prefix))
+	_, prefix, _ = net.ParseCIDR("11.0.0.1/32")
+	assert.Equal(t, errPrefixTooSpecific, lengths.check(*prefix))
+
+	_, prefix, _ = net.ParseCIDR("2000::/3")
+	assert.Nil(t, lengths.check(*prefix))
+	_, prefix, _ = net.ParseCIDR("2001:db9::/64")
+	assert.Equal(t, errPrefixTooSpecific, lengths.check(*prefix))
+
+	assert.Nil(t, PrefixLengths{}.check(*prefix))
+}
+
+func TestMRTFile_CheckPrefix_TooSpecific(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("11.0.0.1/32")
+
+	f := &mrtFile{prefixLengths: PrefixLengths{IPv4Max: 24}}
+	assert.Equal(t, errPrefixTooSpecific, f.checkPrefix(*prefix, mrt.SAFIUnicast))
+
+	// too specific unicast prefixes are analyzed separately, multicast prefixes are still filtered
+	f.tooSpecific = true
+	assert.Nil(t, f.checkPrefix(*prefix, mrt.SAFIUnicast))
+	assert.Equal(t, errPrefixTooSpecific, f.checkPrefix(*prefix, mrt.SAFIMulticast))
+	_, prefix, _ = net.ParseCIDR("10.0.0.0/32")
+	assert.Equal(t, errPrefixReserved, f.checkPrefix(*prefix, mrt.SAFIUnicast))
+}
@@ -0,0 +1,347 @@
+package parser
+
+import (
+	"encoding/csv"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Filter decides which prefixes and origin ASNs are processed. By default, the built-in lists of the IANA
+// special-purpose registries are used. They can be replaced by registries loaded from files, and prefixes or ASNs can
+// be allowed or denied regardless of the registries. A nil Filter uses the built-in lists.
+type Filter struct {
+	reservedPrefixes []registryPrefix
+	reservedASNs     []asnRange
+	// customPrefixes and customASNs are set if the registries were loaded from files
+	customPrefixes bool
+	customASNs     bool
+
+	allowPrefixes []*net.IPNet
+	denyPrefixes  []*net.IPNet
+	allowASNs     []asnRange
+	denyASNs      []asnRange
+}
+
+// registryPrefix is an address block of a special-purpose address registry.
+type registryPrefix struct {
+	prefix *net.IPNet
+	// globallyReachable is set for blocks within reserved blocks which may be routed, e.g. anycast addresses
+	globallyReachable bool
+}
+
+type asnRange struct {
+	first int
+	last  int
+}
+
+// FilterFiles names the files from which a Filter is loaded. Every list may contain multiple files.
+//
+// A file is either a CSV file of an IANA special-purpose registry (e.g. iana-ipv4-special-registry-1.csv or
+// iana-as-numbers-special-registry-1.csv) or a simple list with one prefix, ASN or ASN range (e.g. "64512-65534") per
+// line. Empty lines and lines starting with '#' are ignored.
+type FilterFiles struct {
+	// ReservedPrefixes replace the built-in special-purpose address registries. Only blocks which are not globally
+	// reachable according to a registry are reserved.
+	ReservedPrefixes []string
+	// ReservedASNs replace the built-in special-purpose AS number registry.
+	ReservedASNs []string
+	// AllowPrefixes and AllowASNs are processed even if they are reserved. Prefixes are allowed if they are within an
+	// allowed prefix.
+	AllowPrefixes []string
+	AllowASNs     []string
+	// DenyPrefixes and DenyASNs are never processed. They take precedence over the allowed prefixes and ASNs.
+	DenyPrefixes []string
+	DenyASNs     []string
+}
+
+// LoadFilter loads the registries and lists of the filter from the files.
+func LoadFilter(files FilterFiles) (*Filter, error) {
+	filter := &Filter{
+		customPrefixes: len(files.ReservedPrefixes) > 0,
+		customASNs:     len(files.ReservedASNs) > 0,
+	}
+
+	var err error
+	filter.reservedPrefixes, err = loadPrefixFiles(files.ReservedPrefixes)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading reserved prefixes failed")
+	}
+	filter.reservedASNs, err = loadASNFiles(files.ReservedASNs)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading reserved ASNs failed")
+	}
+
+	allowPrefixes, err := loadPrefixFiles(files.AllowPrefixes)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading allowed prefixes failed")
+	}
+	for _, prefix := range allowPrefixes {
+		filter.allowPrefixes = append(filter.allowPrefixes, prefix.prefix)
+	}
+	denyPrefixes, err := loadPrefixFiles(files.DenyPrefixes)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading denied prefixes failed")
+	}
+	for _, prefix := range denyPrefixes {
+		filter.denyPrefixes = append(filter.denyPrefixes, prefix.prefix)
+	}
+
+	filter.allowASNs, err = loadASNFiles(files.AllowASNs)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading allowed ASNs failed")
+	}
+	filter.denyASNs, err = loadASNFiles(files.DenyASNs)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading denied ASNs failed")
+	}
+
+	return filter, nil
+}
+
+// checkPrefix returns an error if the prefix is not usable, reserved or denied.
+func (f *Filter) checkPrefix(prefix net.IPNet) error {
+	err := validatePrefix(prefix)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return filterReservedPrefix(prefix)
+	}
+
+	if containsPrefix(f.denyPrefixes, prefix) {
+		return errPrefixDenied
+	}
+	if containsPrefix(f.allowPrefixes, prefix) {
+		return nil
+	}
+	if !f.customPrefixes {
+		return filterReservedPrefix(prefix)
+	}
+
+	// like the built-in lists, the most specific block which contains the network address decides
+	var match *registryPrefix
+	for i, reserved := range f.reservedPrefixes {
+		if !reserved.prefix.Contains(prefix.IP) {
+			continue
+		}
+		if match == nil || prefixLength(reserved.prefix) > prefixLength(match.prefix) {
+			match = &f.reservedPrefixes[i]
+		}
+	}
+	if match != nil && !match.globallyReachable {
+		return errPrefixReserved
+	}
+	return nil
+}
+
+// checkASN returns an error if the ASN is reserved or denied.
+func (f *Filter) checkASN(asn int) error {
+	if f == nil {
+		return filterASN(asn)
+	}
+
+	if containsASN(f.denyASNs, asn) {
+		return errASNDenied
+	}
+	if containsASN(f.allowASNs, asn) {
+		return nil
+	}
+	if !f.customASNs {
+		return filterASN(asn)
+	}
+
+	if containsASN(f.reservedASNs, asn) {
+		return errASNReserved
+	}
+	return nil
+}
+
+// containsPrefix returns true if the prefix is within one of the prefixes.
+func containsPrefix(prefixes []*net.IPNet, prefix net.IPNet) bool {
+	for _, p := range prefixes {
+		if p.Contains(prefix.IP) && prefixLength(p) <= prefixLength(&prefix) && len(p.Mask) == len(prefix.Mask) {
+			return true
+		}
+	}
+	return false
+}
+
+func prefixLength(prefix *net.IPNet) int {
+	length, _ := prefix.Mask.Size()
+	return length
+}
+
+func containsASN(ranges []asnRange, asn int) bool {
+	for _, r := range ranges {
+		if asn >= r.first && asn <= r.last {
+			return true
+		}
+	}
+	return false
+}
+
+func loadPrefixFiles(paths []string) ([]registryPrefix, error) {
+	var prefixes []registryPrefix
+	for _, path := range paths {
+		entries, err := readListFile(path, func(value string) bool {
+			_, err := parseListPrefix(value)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			for _, value := range entry.values {
+				prefix, err := parseListPrefix(value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid prefix in file '%s' line %d", path, entry.line)
+				}
+				prefixes = append(prefixes, registryPrefix{
+					prefix:            prefix,
+					globallyReachable: entry.globallyReachable,
+				})
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// parseListPrefix parses a prefix, a single address is treated as a host prefix.
+func parseListPrefix(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, errors.Errorf("invalid address '%s'", value)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil
+	}
+
+	_, prefix, err := net.ParseCIDR(value)
+	return prefix, err
+}
+
+func loadASNFiles(paths []string) ([]asnRange, error) {
+	var ranges []asnRange
+	for _, path := range paths {
+		entries, err := readListFile(path, func(value string) bool {
+			_, err := parseASNRange(value)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			for _, value := range entry.values {
+				r, err := parseASNRange(value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid ASN in file '%s' line %d", path, entry.line)
+				}
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	return ranges, nil
+}
+
+// parseASNRange parses an ASN (e.g. "64512" or "AS64512") or a range of ASNs (e.g. "64512-65534").
+func parseASNRange(value string) (asnRange, error) {
+	parseASN := func(asn string) (int, error) {
+		asn = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS")
+		parsed, err := strconv.ParseUint(asn, 10, 32)
+		return int(parsed), err
+	}
+
+	bounds := strings.SplitN(value, "-", 2)
+	first, err := parseASN(bounds[0])
+	if err != nil {
+		return asnRange{}, err
+	}
+	last := first
+	if len(bounds) == 2 {
+		last, err = parseASN(bounds[1])
+		if err != nil {
+			return asnRange{}, err
+		}
+		if last < first {
+			return asnRange{}, errors.Errorf("invalid ASN range '%s'", value)
+		}
+	}
+	return asnRange{first: first, last: last}, nil
+}
+
+// listEntry is a line of a list file.
+type listEntry struct {
+	line int
+	// values are the values of the first column, which may contain multiple values separated by commas
+	values []string
+	// globallyReachable is the "Globally Reachable" column of the IANA special-purpose address registries
+	globallyReachable bool
+}
+
+// readListFile reads a CSV file or a simple list. The first line is treated as header if valid returns false for its
+// first value.
+func readListFile(path string, valid func(value string) bool) ([]listEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening file failed")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	var entries []listEntry
+	globallyReachableColumn := -1
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "reading file '%s' failed", path)
+		}
+		line, _ := reader.FieldPos(0)
+
+		var values []string
+		for _, value := range strings.Split(record[0], ",") {
+			// footnotes and comments follow the value, e.g. "192.0.0.0/24 [2]"
+			if fields := strings.Fields(value); len(fields) > 0 {
+				values = append(values, fields[0])
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		if first && !valid(values[0]) {
+			for i, column := range record {
+				if strings.EqualFold(strings.TrimSpace(column), "Globally Reachable") {
+					globallyReachableColumn = i
+				}
+			}
+			continue
+		}
+
+		entry := listEntry{
+			line:   line,
+			values: values,
+		}
+		if globallyReachableColumn >= 0 && globallyReachableColumn < len(record) {
+			// blocks which are not explicitly marked as not globally reachable, e.g. deprecated blocks, are not reserved
+			entry.globallyReachable = !strings.HasPrefix(strings.TrimSpace(record[globallyReachableColumn]), "False")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
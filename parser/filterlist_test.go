@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeListFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func checkPrefix(filter *Filter, cidr string) error {
+	_, prefix, _ := net.ParseCIDR(cidr)
+	return filter.checkPrefix(*prefix)
+}
+
+func TestLoadFilter_IANARegistries(t *testing.T) {
+	ipv4 := writeListFile(t, "iana-ipv4-special-registry-1.csv", `Address Block,Name,RFC,Allocation Date,Termination Date,Source,Destination,Forwardable,Globally Reachable,Reserved-by-Protocol
+0.0.0.0/8,"""This network""","[RFC791], Section 3.2",1981-09,N/A,True,False,False,False,True
+192.0.0.0/24 [2],IETF Protocol Assignments,"[RFC6890], Section 2.1",2010-01,N/A,False,False,False,False,False
+192.0.0.9/32,Port Control Protocol Anycast,[RFC7723],2015-10,N/A,True,True,True,True,False
+"192.0.0.170/32, 192.0.0.171/32",NAT64/DNS64 Discovery,"[RFC8880][RFC7050], Section 2.2",2013-02,N/A,False,False,False,False,True
+192.88.99.0/24,Deprecated (6to4 Relay Anycast),[RFC7526],2001-06,2015-03,,,,,
+`)
+	asns := writeListFile(t, "iana-as-numbers-special-registry-1.csv", `AS Number,Reason for Reservation,Reference
+23456,AS_TRANS,[RFC6793]
+64512-65534,For Private Use,[RFC6996]
+`)
+
+	filter, err := LoadFilter(FilterFiles{ReservedPrefixes: []string{ipv4}, ReservedASNs: []string{asns}})
+	assert.NoError(t, err)
+
+	assert.Error(t, checkPrefix(filter, "0.2.0.0/16"))
+	assert.Error(t, checkPrefix(filter, "192.0.0.0/29"))
+	assert.Error(t, checkPrefix(filter, "192.0.0.171/32"))
+	assert.NoError(t, checkPrefix(filter, "192.0.0.9/32"))
+	assert.NoError(t, checkPrefix(filter, "192.88.99.0/24"))
+	// the built-in lists are replaced
+	assert.NoError(t, checkPrefix(filter, "10.0.0.0/8"))
+
+	assert.Error(t, filter.checkASN(23456))
+	assert.Error(t, filter.checkASN(65000))
+	assert.NoError(t, filter.checkASN(0))
+}
+
+func TestLoadFilter_Overrides(t *testing.T) {
+	denyPrefixes := writeListFile(t, "deny.txt", "# lab space\n11.0.0.0/16\n\n2001:db9::/32 # lab v6\n")
+	allowPrefixes := writeListFile(t, "allow.txt", "10.1.0.0/16\n11.0.0.0/8\n")
+	denyASNs := writeListFile(t, "deny-asns.txt", "AS3333\n")
+	allowASNs := writeListFile(t, "allow-asns.txt", "65000-65010\n")
+
+	filter, err := LoadFilter(FilterFiles{
+		AllowPrefixes: []string{allowPrefixes},
+		DenyPrefixes:  []string{denyPrefixes},
+		AllowASNs:     []string{allowASNs},
+		DenyASNs:      []string{denyASNs},
+	})
+	assert.NoError(t, err)
+
+	assert.Error(t, checkPrefix(filter, "11.0.1.0/24"))
+	assert.NoError(t, checkPrefix(filter, "11.1.0.0/16"))
+	assert.Error(t, checkPrefix(filter, "2001:db9:1::/48"))
+	assert.NoError(t, checkPrefix(filter, "10.1.2.0/24"))
+	// the built-in lists are used for the other prefixes
+	assert.Error(t, checkPrefix(filter, "10.2.0.0/16"))
+	assert.Error(t, checkPrefix(filter, "10.0.0.0/8"))
+
+	assert.Error(t, filter.checkASN(3333))
+	assert.NoError(t, filter.checkASN(65005))
+	assert.Error(t, filter.checkASN(65011))
+	assert.NoError(t, filter.checkASN(1103))
+}
+
+func TestLoadFilter_Invalid(t *testing.T) {
+	asns := writeListFile(t, "asns.txt", "64512\nfoo\n")
+	_, err := LoadFilter(FilterFiles{DenyASNs: []string{asns}})
+	assert.Error(t, err)
+
+	_, err = LoadFilter(FilterFiles{DenyPrefixes: []string{filepath.Join(t.TempDir(), "missing.txt")}})
+	assert.Error(t, err)
+}
+
+func TestFilter_Nil(t *testing.T) {
+	var filter *Filter
+	assert.Error(t, checkPrefix(filter, "10.0.0.0/8"))
+	assert.NoError(t, checkPrefix(filter, "11.0.0.0/8"))
+	assert.Error(t, filter.checkASN(64512))
+}
+
+func TestFilterRoute(t *testing.T) {
+	peer := routes.Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	attributes := func(origin uint32) []*mrt.BGPPathAttribute {
+		return []*mrt.BGPPathAttribute{{TypeCode: bgpAttributeASPath, Value: mrt.BGPPathAttributeASPath{asSequence(as4(3333), as4(origin))}}}
+	}
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	_, public, _ := net.ParseCIDR("193.0.0.0/21")
+
+	f := &mrtFile{}
+	f.processAnnouncements([]*net.IPNet{private}, mrt.SAFIUnicast, false, attributes(1103), nil, peer, time.Time{})
+	f.processAnnouncements([]*net.IPNet{private}, mrt.SAFIUnicast, false, attributes(1103), nil, peer, time.Time{})
+	f.processAnnouncements([]*net.IPNet{public}, mrt.SAFIMulticast, true, attributes(64512), nil, peer, time.Time{})
+	f.processAnnouncements([]*net.IPNet{public}, mrt.SAFIUnicast, false, nil, nil, peer, time.Time{})
+
+	assert.Equal(t, map[string]int{
+		routes.FilterReasonReservedPrefix: 2,
+		routes.FilterReasonReservedASN:    1,
+		routes.FilterReasonMissingASPath:  1,
+	}, f.statistics.Filtered)
+	assert.Equal(t, map[routes.FilteredAnnouncement]struct{}{
+		{Reason: routes.FilterReasonReservedPrefix, Prefix: "10.0.0.0/8", OriginAS: "1103", ReceivedBy: peer}:                  {},
+		{Reason: routes.FilterReasonReservedASN, Prefix: "193.0.0.0/21", OriginAS: "64512", ReceivedBy: peer, Multicast: true}: {},
+		{Reason: routes.FilterReasonMissingASPath, Prefix: "193.0.0.0/21", ReceivedBy: peer}:                                   {},
+	}, f.filtered)
+}
@@ -0,0 +1,336 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The functions of this file encode MRT records for the tests. All records are timestamped with the given UNIX time
+// and use 192.0.2.254 as the local address of the collector.
+
+const testTime = 1600000000
+
+// mrtRecordAt encodes an MRT record with the given timestamp, type, subtype and body.
+func mrtRecordAt(timestamp uint32, recordType mrt.RecordType, subtype uint16, body []byte) []byte {
+	data := make([]byte, mrtHeaderLength)
+	binary.BigEndian.PutUint32(data, timestamp)
+	binary.BigEndian.PutUint16(data[4:], uint16(recordType))
+	binary.BigEndian.PutUint16(data[6:], subtype)
+	binary.BigEndian.PutUint32(data[8:], uint32(len(body)))
+	return append(data, body...)
+}
+
+// concat joins the encoded records or attributes.
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func putUint16(b *bytes.Buffer, v uint16) {
+	_ = binary.Write(b, binary.BigEndian, v)
+}
+
+func putUint32(b *bytes.Buffer, v uint32) {
+	_ = binary.Write(b, binary.BigEndian, v)
+}
+
+// encodeIP returns the 4-byte representation of IPv4 addresses and the 16-byte representation otherwise.
+func encodeIP(address string) []byte {
+	ip := net.ParseIP(address)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// encodeNLRI encodes the prefix as length and significant octets.
+func encodeNLRI(prefix string) []byte {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		panic(err)
+	}
+	length, _ := network.Mask.Size()
+	return append([]byte{byte(length)}, encodeIP(network.IP.String())[:(length+7)/8]...)
+}
+
+// encodeAddPathNLRI encodes the prefix with a preceding path ID (RFC 7911).
+func encodeAddPathNLRI(pathID uint32, prefix string) []byte {
+	var b bytes.Buffer
+	putUint32(&b, pathID)
+	b.Write(encodeNLRI(prefix))
+	return b.Bytes()
+}
+
+// bgpAttribute encodes a path attribute, the extended length flag is set if required.
+func bgpAttribute(flags, code uint8, value []byte) []byte {
+	var b bytes.Buffer
+	if len(value) > 255 {
+		b.Write([]byte{flags | 0x10, code})
+		putUint16(&b, uint16(len(value)))
+	} else {
+		b.Write([]byte{flags, code, byte(len(value))})
+	}
+	b.Write(value)
+	return b.Bytes()
+}
+
+type asPathSegment struct {
+	segmentType mrt.BGPASPathSegmentType
+	asns        []uint32
+}
+
+func asSequenceSegment(asns ...uint32) asPathSegment {
+	return asPathSegment{segmentType: mrt.BGPASPathSegmentTypeASSequence, asns: asns}
+}
+
+// encodeASPath encodes the segments with 2-byte or 4-byte ASNs.
+func encodeASPath(as4 bool, segments ...asPathSegment) []byte {
+	var b bytes.Buffer
+	for _, segment := range segments {
+		b.Write([]byte{byte(segment.segmentType), byte(len(segment.asns))})
+		for _, asn := range segment.asns {
+			if as4 {
+				putUint32(&b, asn)
+			} else {
+				putUint16(&b, uint16(asn))
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func asPathAttribute(as4 bool, segments ...asPathSegment) []byte {
+	return bgpAttribute(0x40, 2, encodeASPath(as4, segments...))
+}
+
+func as4PathAttribute(segments ...asPathSegment) []byte {
+	return bgpAttribute(0xc0, 17, encodeASPath(true, segments...))
+}
+
+func originAttribute() []byte {
+	return bgpAttribute(0x40, 1, []byte{0})
+}
+
+func nextHopAttribute() []byte {
+	return bgpAttribute(0x40, 3, encodeIP("192.0.2.254"))
+}
+
+// mpReachAttribute encodes an MP_REACH_NLRI attribute of IPv6 unicast prefixes.
+func mpReachAttribute(nlri ...[]byte) []byte {
+	var b bytes.Buffer
+	putUint16(&b, uint16(mrt.AFIIPv6))
+	b.WriteByte(byte(mrt.SAFIUnicast))
+	b.WriteByte(net.IPv6len)
+	b.Write(encodeIP("2001:db8::254"))
+	b.WriteByte(0)
+	b.Write(concat(nlri...))
+	return bgpAttribute(0x80, 14, b.Bytes())
+}
+
+// mpUnreachAttribute encodes an MP_UNREACH_NLRI attribute of IPv6 unicast prefixes.
+func mpUnreachAttribute(nlri ...[]byte) []byte {
+	var b bytes.Buffer
+	putUint16(&b, uint16(mrt.AFIIPv6))
+	b.WriteByte(byte(mrt.SAFIUnicast))
+	b.Write(concat(nlri...))
+	return bgpAttribute(0x80, 15, b.Bytes())
+}
+
+// testPeer is a peer of a peer index table.
+type testPeer struct {
+	as uint32
+	ip string
+}
+
+// peerIndexTable encodes a PEER_INDEX_TABLE of the collector 192.0.2.254 with 4-byte ASNs.
+func peerIndexTable(timestamp uint32, peers ...testPeer) []byte {
+	return collectorPeerIndexTable(timestamp, "192.0.2.254", peers...)
+}
+
+// collectorPeerIndexTable encodes a PEER_INDEX_TABLE of the collector with the BGP ID with 4-byte ASNs.
+func collectorPeerIndexTable(timestamp uint32, collectorBGPID string, peers ...testPeer) []byte {
+	var b bytes.Buffer
+	b.Write(encodeIP(collectorBGPID))
+	putUint16(&b, 0)
+	putUint16(&b, uint16(len(peers)))
+	for _, peer := range peers {
+		peerType := byte(0x02)
+		if net.ParseIP(peer.ip).To4() == nil {
+			peerType |= 0x01
+		}
+		b.WriteByte(peerType)
+		b.Write(encodeIP("192.0.2.254"))
+		b.Write(encodeIP(peer.ip))
+		putUint32(&b, peer.as)
+	}
+	return mrtRecordAt(timestamp, mrt.TYPE_TABLE_DUMP_V2, mrt.TABLE_DUMP_V2_SUBTYPE_PEER_INDEX_TABLE, b.Bytes())
+}
+
+// testRIBEntry is the route of the peer with the index in the peer index table. The path ID is only encoded for the
+// ADDPATH subtypes.
+type testRIBEntry struct {
+	peerIndex  uint16
+	pathID     uint32
+	attributes []byte
+}
+
+// encodeRIBEntries encodes the sequence number, the prefix and the RIB entries of a RIB record.
+func encodeRIBEntries(timestamp uint32, sequence uint32, nlri []byte, addPath bool, entries []testRIBEntry) []byte {
+	var b bytes.Buffer
+	putUint32(&b, sequence)
+	b.Write(nlri)
+	putUint16(&b, uint16(len(entries)))
+	for _, entry := range entries {
+		putUint16(&b, entry.peerIndex)
+		putUint32(&b, timestamp)
+		if addPath {
+			putUint32(&b, entry.pathID)
+		}
+		putUint16(&b, uint16(len(entry.attributes)))
+		b.Write(entry.attributes)
+	}
+	return b.Bytes()
+}
+
+// ribRecord encodes an AFI/SAFI-specific RIB record.
+func ribRecord(timestamp uint32, subtype uint16, sequence uint32, prefix string, entries ...testRIBEntry) []byte {
+	addPath := subtype >= mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST_ADDPATH
+	body := encodeRIBEntries(timestamp, sequence, encodeNLRI(prefix), addPath, entries)
+	return mrtRecordAt(timestamp, mrt.TYPE_TABLE_DUMP_V2, subtype, body)
+}
+
+// ribGenericRecord encodes a RIB_GENERIC or RIB_GENERIC_ADDPATH record.
+func ribGenericRecord(timestamp uint32, subtype uint16, afi mrt.AFI, safi mrt.SAFI, prefix string, entries ...testRIBEntry) []byte {
+	addPath := subtype == mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH
+	body := encodeRIBEntries(timestamp, 0, encodeNLRI(prefix), addPath, entries)
+	var b bytes.Buffer
+	b.Write(body[:4])
+	putUint16(&b, uint16(afi))
+	b.WriteByte(byte(safi))
+	b.Write(body[4:])
+	return mrtRecordAt(timestamp, mrt.TYPE_TABLE_DUMP_V2, subtype, b.Bytes())
+}
+
+// tableDumpRecord encodes a legacy TABLE_DUMP record with a 2-byte peer AS.
+func tableDumpRecord(timestamp uint32, prefix string, peer testPeer, attributes []byte) []byte {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		panic(err)
+	}
+	length, _ := network.Mask.Size()
+	subtype := uint16(mrt.TABLE_DUMP_SUBTYPE_AFI_IPv4)
+	if network.IP.To4() == nil {
+		subtype = mrt.TABLE_DUMP_SUBTYPE_AFI_IPv6
+	}
+
+	var b bytes.Buffer
+	putUint16(&b, 0)
+	putUint16(&b, 0)
+	b.Write(encodeIP(network.IP.String()))
+	b.Write([]byte{byte(length), 1})
+	putUint32(&b, timestamp)
+	b.Write(encodeIP(peer.ip))
+	putUint16(&b, uint16(peer.as))
+	putUint16(&b, uint16(len(attributes)))
+	b.Write(attributes)
+	return mrtRecordAt(timestamp, mrt.TYPE_TABLE_DUMP, subtype, b.Bytes())
+}
+
+// bgpUpdate encodes a BGP UPDATE message with the encoded withdrawn routes, path attributes and NLRI.
+func bgpUpdate(withdrawn, attributes, nlri []byte) []byte {
+	var b bytes.Buffer
+	b.Write(bytes.Repeat([]byte{0xff}, 16))
+	putUint16(&b, uint16(19+2+len(withdrawn)+2+len(attributes)+len(nlri)))
+	b.WriteByte(2)
+	putUint16(&b, uint16(len(withdrawn)))
+	b.Write(withdrawn)
+	putUint16(&b, uint16(len(attributes)))
+	b.Write(attributes)
+	b.Write(nlri)
+	return b.Bytes()
+}
+
+// bgp4mpHeader encodes the peer and local AS, interface index, AFI and addresses of a BGP4MP record.
+func bgp4mpHeader(as4 bool, peer testPeer) []byte {
+	var b bytes.Buffer
+	if as4 {
+		putUint32(&b, peer.as)
+		putUint32(&b, 12654)
+	} else {
+		putUint16(&b, uint16(peer.as))
+		putUint16(&b, 12654)
+	}
+	putUint16(&b, 0)
+	if net.ParseIP(peer.ip).To4() != nil {
+		putUint16(&b, uint16(mrt.AFIIPv4))
+		b.Write(encodeIP(peer.ip))
+		b.Write(encodeIP("192.0.2.254"))
+	} else {
+		putUint16(&b, uint16(mrt.AFIIPv6))
+		b.Write(encodeIP(peer.ip))
+		b.Write(encodeIP("2001:db8::254"))
+	}
+	return b.Bytes()
+}
+
+// bgp4mpMessage encodes a BGP4MP record of one of the MESSAGE subtypes. The ASNs of the header are encoded with 4
+// bytes for the AS4 subtypes.
+func bgp4mpMessage(timestamp uint32, subtype uint16, peer testPeer, message []byte) []byte {
+	as4 := subtype == mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4 ||
+		subtype == mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL ||
+		subtype == mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH ||
+		subtype == mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL_ADDPATH
+	return mrtRecordAt(timestamp, mrt.TYPE_BGP4MP, subtype, concat(bgp4mpHeader(as4, peer), message))
+}
+
+// bgp4mpETMessage encodes a BGP4MP_ET record of one of the MESSAGE subtypes, whose timestamp has the microseconds.
+func bgp4mpETMessage(timestamp, microseconds uint32, subtype uint16, peer testPeer, message []byte) []byte {
+	data := bgp4mpMessage(timestamp, subtype, peer, message)
+	var b bytes.Buffer
+	putUint32(&b, microseconds)
+	return mrtRecordAt(timestamp, mrt.TYPE_BGP4MP_ET, subtype, concat(b.Bytes(), data[mrtHeaderLength:]))
+}
+
+// bgp4mpStateChange encodes a BGP4MP_STATE_CHANGE_AS4 record.
+func bgp4mpStateChange(timestamp uint32, peer testPeer, oldState, newState uint16) []byte {
+	var b bytes.Buffer
+	b.Write(bgp4mpHeader(true, peer))
+	putUint16(&b, oldState)
+	putUint16(&b, newState)
+	return mrtRecordAt(timestamp, mrt.TYPE_BGP4MP, mrt.BGP4MP_SUBTYPE_BGP4MP_STATE_CHANGE_AS4, b.Bytes())
+}
+
+// detectMOASInFS processes the files like the command does and returns the output directory.
+func detectMOASInFS(t *testing.T, fsys fs.FS, config Config) string {
+	return detectMOAS(t, func(channels routes.Channels) {
+		ProcessFS(fsys, channels, config)
+	}, config)
+}
+
+// detectMOAS runs process, which has to close the channels, like the command does and returns the output directory.
+func detectMOAS(t *testing.T, process func(channels routes.Channels), config Config) string {
+	channels := routes.NewChannels(2)
+	go process(channels)
+
+	r := routes.NewRoutes(config.Replay, nil, config.TooSpecific)
+	require.NoError(t, r.HandleAnnouncements(channels))
+
+	directory := t.TempDir()
+	require.NoError(t, r.PrintMOASPrefixes(directory))
+	return directory
+}
+
+// readOutput decodes an output file of detectMOASInFS.
+func readOutput(t *testing.T, directory, name string, v interface{}) {
+	data, err := os.ReadFile(filepath.Join(directory, name))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+}
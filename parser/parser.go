@@ -1,10 +1,9 @@
 package parser
 
 import (
-	"compress/bzip2"
-	"compress/gzip"
 	"github.com/TheFireMike/go-mrt"
 	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"io"
@@ -16,232 +15,987 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 )
 
+var errUnknownFileType = errors.New("unknown file type")
+
+// BGP FSM state as used in BGP4MP_STATE_CHANGE records
+const bgpStateEstablished = 6
+
+// Config controls which files and announcements are processed.
+type Config struct {
+	// Peers limits the processed announcements to these peer ASNs (default all).
+	Peers []string
+	// IgnoreRegex skips all files whose path matches.
+	IgnoreRegex *string
+	// Replay applies the updates in timestamp order on top of the table dumps instead of processing all files
+	// concurrently.
+	Replay bool
+	// ReplayUntil stops the replay at this time (default all updates).
+	ReplayUntil time.Time
+	// Workers limits the number of files which are opened and decompressed concurrently (default 1).
+	Workers int
+	// Snapshots selects the table dumps which are processed (default all).
+	Snapshots SnapshotSelection
+	// MaxSkew is the maximum time between the table dumps, and between the table dumps and the start of the update
+	// files, before a warning is logged (default no check).
+	MaxSkew time.Duration
+	// RefuseSkew aborts the run instead of logging a warning if MaxSkew is exceeded.
+	RefuseSkew bool
+	// Filter decides which prefixes and origin ASNs are processed (default the built-in special-purpose registries).
+	Filter *Filter
+	// PrefixLengths limits the lengths of the processed prefixes (default no limits).
+	PrefixLengths PrefixLengths
+	// TooSpecific passes unicast prefixes which are longer than the maximum length to separate views instead of
+	// filtering them.
+	TooSpecific bool
+	// RouteAttributes adds the AS path, next hop, origin and communities of every route to the announcements.
+	RouteAttributes bool
+	// ContinueOnError records the error of a failing file in its statistics and continues with the other files
+	// instead of passing the error on, which aborts the run.
+	ContinueOnError bool
+
+	// abort stops the processing after the first error of a file, it is set for every run unless ContinueOnError is
+	// set.
+	abort *abortSignal
+}
+
+// abortSignal is triggered by the first error of a file, so the remaining files and records are not processed. A nil
+// signal is never triggered.
+type abortSignal struct {
+	once sync.Once
+	done chan struct{}
+}
+
+// withAbortSignal returns the config with a new abort signal for a run, unless errors do not abort the run.
+func withAbortSignal(config Config) Config {
+	if !config.ContinueOnError {
+		config.abort = &abortSignal{done: make(chan struct{})}
+	}
+	return config
+}
+
+func (a *abortSignal) trigger() {
+	if a != nil {
+		a.once.Do(func() {
+			close(a.done)
+		})
+	}
+}
+
+func (a *abortSignal) aborted() bool {
+	if a == nil {
+		return false
+	}
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
 type mrtFile struct {
 	name        string
+	collector   string
 	logger      zerolog.Logger
 	channels    routes.Channels
 	peers       []routes.Peer
+	knownPeers  map[routes.Peer]struct{}
 	wantedPeers map[string]struct{}
+	batchers    *batchers
+	filter      *Filter
+
+	prefixLengths   PrefixLengths
+	tooSpecific     bool
+	continueOnError bool
+	routeAttributes bool
+	abort           *abortSignal
+
+	source          source
+	file            io.ReadCloser
+	counter         *countingReader
+	opened          time.Time
+	reader          *recordReader
+	closeDecompress func()
+	statistics      routes.FileStatistics
+	// filtered contains the announced routes which were not processed
+	filtered map[routes.FilteredAnnouncement]struct{}
+}
+
+// ProcessFiles processes all files in the directory and the archives it contains. The directory may also be a single
+// file.
+func ProcessFiles(directory string, channels routes.Channels, config Config) {
+	fsys, root, prefix, err := openDirectory(directory)
+	if err != nil {
+		channels.Errors <- errors.Wrap(err, "reading input directory failed")
+		channels.Close()
+		return
+	}
+	processFS(fsys, root, prefix, channels, config)
+}
+
+// ProcessFS processes all files in fsys and the archives it contains.
+func ProcessFS(fsys fs.FS, channels routes.Channels, config Config) {
+	processFS(fsys, ".", "", channels, config)
 }
 
-func ProcessFiles(directory string, channels routes.Channels, peers []string, ignoreRegex *string) {
+// ProcessStream processes a single MRT stream, e.g. stdin.
+func ProcessStream(r io.Reader, name string, channels routes.Channels, config Config) {
 	defer channels.Close()
 
-	peersMap := make(map[string]struct{})
-	for _, peer := range peers {
-		peersMap[peer] = struct{}{}
+	config = withAbortSignal(config)
+	f := newMRTFile(source{
+		name: name,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	}, channels, config, getWantedPeers(config))
+
+	if config.Replay {
+		replayStream(f, config.ReplayUntil)
+		return
 	}
 
-	wg := sync.WaitGroup{}
-	err := filepath.WalkDir(directory, func(s string, d fs.DirEntry, err error) error {
+	f.process()
+}
+
+// openDirectory returns the file system of the directory, the root of the files to process in it and the prefix of
+// their names. If the directory is a single file, the root is the file.
+func openDirectory(directory string) (fs.FS, string, string, error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	root := "."
+	if !info.IsDir() {
+		root = filepath.Base(directory)
+		directory = filepath.Dir(directory)
+	}
+	return os.DirFS(directory), root, directory, nil
+}
+
+// processFS processes all files below root in fsys. The file names are prefixed with prefix.
+func processFS(fsys fs.FS, root, prefix string, channels routes.Channels, config Config) {
+	defer channels.Close()
+
+	config = withAbortSignal(config)
+	sources, brokenSources, err := findSources(fsys, root, prefix, config)
+	if err != nil {
+		channels.Errors <- errors.Wrap(err, "reading input directory failed")
+		return
+	}
+
+	peersMap := getWantedPeers(config)
+	for _, broken := range brokenSources {
+		// a broken archive does not stop the processing of the other files
+		newMRTFile(source{name: broken.name}, channels, config, peersMap).fail(broken.err)
+	}
+
+	processSources(sources, channels, config, config.Snapshots)
+}
+
+// brokenSource is a file which could not be read, e.g. a corrupt archive.
+type brokenSource struct {
+	name string
+	err  error
+}
+
+// findSources returns all files below root in fsys which are not ignored, including the files in archives. Files
+// which could not be read are returned separately.
+func findSources(fsys fs.FS, root, prefix string, config Config) ([]source, []brokenSource, error) {
+	isIgnored := func(name string) bool {
+		if config.IgnoreRegex == nil {
+			return false
+		}
+		match, err := regexp.MatchString(*config.IgnoreRegex, name)
+		return err == nil && match
+	}
+
+	var sources []source
+	var brokenSources []brokenSource
+	err := fs.WalkDir(fsys, root, func(s string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if ignoreRegex != nil {
-			if match, err := regexp.MatchString(*ignoreRegex, s); err == nil && match {
-				return nil
-			}
+		name := filepath.Join(prefix, s)
+		if isIgnored(name) || d.IsDir() {
+			return nil
 		}
-		if !d.IsDir() {
-			wg.Add(1)
-			f := mrtFile{
-				name:        s,
-				logger:      log.With().Str("file", s).Logger(),
-				channels:    channels,
-				wantedPeers: peersMap,
+
+		fileSources, err := getSources(name, func() (io.ReadCloser, error) {
+			return fsys.Open(s)
+		})
+		if err != nil {
+			brokenSources = append(brokenSources, brokenSource{
+				name: name,
+				err:  errors.Wrap(err, "reading archive failed"),
+			})
+			return nil
+		}
+		for _, src := range fileSources {
+			if !isIgnored(src.name) {
+				sources = append(sources, src)
 			}
-			go f.process(&wg)
 		}
 		return nil
 	})
-	if err != nil {
-		channels.Errors <- err
+	return sources, brokenSources, err
+}
+
+// processSources processes the files after selecting the table dumps.
+func processSources(sources []source, channels routes.Channels, config Config, selection SnapshotSelection) {
+	peersMap := getWantedPeers(config)
+	var files []*mrtFile
+	for _, src := range sources {
+		files = append(files, newMRTFile(src, channels, config, peersMap))
+	}
+	assignCollectors(files)
+
+	if selection.enabled() {
+		files = selectSnapshots(files, selection)
+	}
+
+	if config.MaxSkew > 0 {
+		if err := checkSkew(files, config.MaxSkew); err != nil {
+			if config.RefuseSkew {
+				channels.Errors <- err
+				return
+			}
+			log.Warn().Err(err).Msg("input files are not from the same snapshot")
+		}
+	}
+
+	if config.Replay {
+		replayFiles(files, config.ReplayUntil, config.Workers)
 		return
 	}
 
+	processConcurrently(files, config.Workers)
+}
+
+// processConcurrently processes the files with a pool of workers, so that at most workers files are open at the same
+// time. After the run was aborted, the remaining files are skipped.
+func processConcurrently(files []*mrtFile, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan *mrtFile)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range queue {
+				if !f.abort.aborted() {
+					f.process()
+				}
+			}
+		}()
+	}
+
+	for _, f := range files {
+		if f.abort.aborted() {
+			break
+		}
+		queue <- f
+	}
+	close(queue)
 	wg.Wait()
 }
 
-func (f *mrtFile) process(wg *sync.WaitGroup) {
-	defer wg.Done()
+func getWantedPeers(config Config) map[string]struct{} {
+	peersMap := make(map[string]struct{})
+	for _, peer := range config.Peers {
+		peersMap[peer] = struct{}{}
+	}
+	return peersMap
+}
+
+func newMRTFile(src source, channels routes.Channels, config Config, wantedPeers map[string]struct{}) *mrtFile {
+	collector := getCollectorFromPath(src.name)
+	return &mrtFile{
+		name:            src.name,
+		collector:       collector,
+		logger:          log.With().Str("file", src.name).Logger(),
+		statistics:      routes.FileStatistics{Name: src.name, Collector: collector},
+		channels:        channels,
+		knownPeers:      make(map[routes.Peer]struct{}),
+		wantedPeers:     wantedPeers,
+		batchers:        newBatchers(channels),
+		filter:          config.Filter,
+		prefixLengths:   config.PrefixLengths,
+		tooSpecific:     config.TooSpecific,
+		continueOnError: config.ContinueOnError,
+		routeAttributes: config.RouteAttributes,
+		abort:           config.abort,
+		source:          src,
+	}
+}
+
+// batchers collect the announcements of a file for the views.
+type batchers struct {
+	ipv4            *routes.Batcher
+	ipv6            *routes.Batcher
+	ipv4Multicast   *routes.Batcher
+	ipv6Multicast   *routes.Batcher
+	ipv4TooSpecific *routes.Batcher
+	ipv6TooSpecific *routes.Batcher
+}
+
+func newBatchers(channels routes.Channels) *batchers {
+	return &batchers{
+		ipv4:            routes.NewBatcher(channels.IPv4),
+		ipv6:            routes.NewBatcher(channels.IPv6),
+		ipv4Multicast:   routes.NewBatcher(channels.IPv4Multicast),
+		ipv6Multicast:   routes.NewBatcher(channels.IPv6Multicast),
+		ipv4TooSpecific: routes.NewBatcher(channels.IPv4TooSpecific),
+		ipv6TooSpecific: routes.NewBatcher(channels.IPv6TooSpecific),
+	}
+}
+
+func (b *batchers) flush() {
+	b.ipv4.Flush()
+	b.ipv6.Flush()
+	b.ipv4Multicast.Flush()
+	b.ipv6Multicast.Flush()
+	b.ipv4TooSpecific.Flush()
+	b.ipv6TooSpecific.Flush()
+}
 
-	fp, err := os.Open(f.name)
+func (f *mrtFile) process() {
+	defer f.report()
+
+	err := f.open()
 	if err != nil {
-		f.channels.Errors <- err
+		f.sendError(err)
 		return
 	}
-	defer func(fp *os.File) {
-		err := fp.Close()
-		if err != nil {
-			f.logger.Error().Err(err).Msg("closing file failed")
+	defer f.close()
+
+	for {
+		rec, err := f.next()
+		if err == io.EOF {
+			break
 		}
-	}(fp)
+		f.processRecord(rec)
+	}
+	f.batchers.flush()
+}
 
-	var content io.Reader
-	switch fileType := filepath.Ext(fp.Name()); fileType {
-	case ".gz":
-		content, err = gzip.NewReader(fp)
-		if err != nil {
-			f.channels.Errors <- err
-			return
+// open opens the file and sets up an MRT reader on its decompressed content.
+func (f *mrtFile) open() error {
+	file, err := f.source.open()
+	if err != nil {
+		return err
+	}
+
+	counter := &countingReader{reader: file}
+	content, format, closeDecompress, err := decompress(counter)
+	f.statistics.Format = format
+	if err != nil {
+		f.statistics.Bytes += counter.n
+		_ = file.Close()
+		if errors.Is(err, errUnknownFileType) {
+			f.logger.Error().Msg("unknown file type found")
 		}
-	case ".bz2":
-		content = bzip2.NewReader(fp)
-	default:
-		f.logger.Error().Msg("unknown file type found: " + fileType)
-		return
+		return err
+	}
+
+	f.file = file
+	f.counter = counter
+	f.opened = time.Now()
+	f.reader = newRecordReader(content)
+	f.closeDecompress = closeDecompress
+	return nil
+}
+
+func (f *mrtFile) close() {
+	f.statistics.Bytes += f.counter.n
+	f.statistics.Duration += time.Since(f.opened).Seconds()
+
+	f.closeDecompress()
+	err := f.file.Close()
+	if err != nil {
+		f.logger.Error().Err(err).Msg("closing file failed")
 	}
+}
 
-	mrtReader := mrt.NewReader(content)
+// next returns the next MRT record of the file, or io.EOF if there are no more records or the run was aborted. Records
+// which can not be decoded are skipped. If the file is truncated or corrupt, the file is marked as truncated and io.EOF is returned, so
+// all complete records before are still processed. If reading the file itself fails, the error is recorded like the
+// error of a file which can not be opened and io.EOF is returned as well.
+func (f *mrtFile) next() (mrt.Record, error) {
 	for {
-		rec, err := mrtReader.Next()
+		if f.abort.aborted() {
+			return nil, io.EOF
+		}
+		rec, err := f.reader.Next()
 		if err == io.EOF {
-			break
-		} else if err != nil {
+			return nil, err
+		} else if errors.As(err, &recordError{}) {
 			f.logger.Error().Err(err).Msg("reading MRT entry failed")
 			continue
+		} else if f.counter.err != nil {
+			// the decompressor or record reader only passed on the error of the file
+			f.sendError(errors.Wrap(f.counter.err, "reading file failed"))
+			return nil, io.EOF
+		} else if err != nil {
+			f.statistics.Truncated = true
+			f.logger.Warn().Err(err).Int("records", f.statistics.Records).Msg("file is truncated or corrupt")
+			return nil, io.EOF
 		}
 
+		f.statistics.Records++
+		f.updateObservationTime(rec.Timestamp())
 		switch rec.Type() {
+		case mrt.TYPE_TABLE_DUMP:
+			f.statistics.RIBRecords++
 		case mrt.TYPE_TABLE_DUMP_V2:
-			switch rec.Subtype() {
-			case mrt.TABLE_DUMP_V2_SUBTYPE_PEER_INDEX_TABLE:
-				f.addPeerInformation(rec.(*mrt.TableDumpV2PeerIndexTable))
-			case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST,
-				mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST,
-				mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST_ADDPATH,
-				mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST_ADDPATH:
-				f.processMRTEntry(rec.(*mrt.TableDumpV2RIB))
-			default:
-				f.logger.Trace().Msgf("unknown mrt entry subtype: '%v'", rec.Subtype())
+			if rec.Subtype() == mrt.TABLE_DUMP_V2_SUBTYPE_PEER_INDEX_TABLE {
+				f.statistics.PeerIndexRecords++
+			} else {
+				f.statistics.RIBRecords++
 			}
+		}
+		return rec, nil
+	}
+}
+
+// sendError records an error which occurred while processing the file. Files of an unknown type are only listed in
+// the statistics. Unless errors are recorded per file, the error aborts the run.
+func (f *mrtFile) sendError(err error) {
+	if errors.Is(err, errUnknownFileType) {
+		return
+	}
+
+	f.statistics.Error = err.Error()
+	if f.continueOnError {
+		f.logger.Error().Err(err).Msg("processing file failed")
+		return
+	}
+	f.abort.trigger()
+	f.channels.Errors <- errors.Wrapf(err, "processing file '%s' failed", f.name)
+}
+
+// fail records an error of a file which could not be processed at all and reports it.
+func (f *mrtFile) fail(err error) {
+	f.sendError(err)
+	f.report()
+}
+
+// updateObservationTime extends the time range of the records of the file.
+func (f *mrtFile) updateObservationTime(timestamp time.Time) {
+	if f.statistics.ObservationStart == nil || timestamp.Before(*f.statistics.ObservationStart) {
+		f.statistics.ObservationStart = &timestamp
+	}
+	if f.statistics.ObservationEnd == nil || timestamp.After(*f.statistics.ObservationEnd) {
+		f.statistics.ObservationEnd = &timestamp
+	}
+}
+
+// report passes the statistics of the file to the statistics of the run.
+func (f *mrtFile) report() {
+	if f.statistics.Duration > 0 {
+		f.statistics.Throughput = float64(f.statistics.Bytes) / f.statistics.Duration
+	}
+	f.logger.Debug().
+		Int64("bytes", f.statistics.Bytes).
+		Int("records", f.statistics.Records).
+		Float64("duration", f.statistics.Duration).
+		Msg("file processed")
+	if len(f.filtered) > 0 {
+		filtered := make([]routes.FilteredAnnouncement, 0, len(f.filtered))
+		for announcement := range f.filtered {
+			filtered = append(filtered, announcement)
+		}
+		f.channels.Filtered <- filtered
+	}
+	f.channels.Files <- f.statistics
+}
+
+// countingReader counts the bytes read from the underlying reader and keeps its first error other than io.EOF.
+type countingReader struct {
+	reader io.Reader
+	n      int64
+	err    error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (f *mrtFile) processRecord(rec mrt.Record) {
+	timestamp := rec.Timestamp()
+	if extended, ok := rec.(*extendedTimestampRecord); ok {
+		rec = extended.Record
+	}
+
+	switch rec.Type() {
+	case mrt.TYPE_TABLE_DUMP:
+		f.processTableDump(rec.(*mrt.TableDump))
+	case mrt.TYPE_TABLE_DUMP_V2:
+		switch rec.Subtype() {
+		case mrt.TABLE_DUMP_V2_SUBTYPE_PEER_INDEX_TABLE:
+			f.addPeerInformation(rec.(*mrt.TableDumpV2PeerIndexTable))
+		case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST_ADDPATH,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST_ADDPATH:
+			f.processMRTEntry(rec.(*mrt.TableDumpV2RIB), mrt.SAFIUnicast)
+		case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST_ADDPATH,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST_ADDPATH:
+			f.processMRTEntry(rec.(*mrt.TableDumpV2RIB), mrt.SAFIMulticast)
+		case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH:
+			// the record reader converts all supported RIB_GENERIC records to AFI/SAFI-specific records
+			generic := rec.(*mrt.TableDumpV2RIBGeneric)
+			f.logger.Trace().Msgf("unsupported RIB_GENERIC AFI/SAFI: '%v/%v'", generic.AFI, generic.SAFI)
+		default:
+			f.logger.Trace().Msgf("unknown mrt entry subtype: '%v'", rec.Subtype())
+		}
+	case mrt.TYPE_BGP4MP, mrt.TYPE_BGP4MP_ET:
+		switch rec.Subtype() {
+		case mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4:
+			f.processBGP4MPMessage(rec.(*mrt.BGP4MPMessage), nil, timestamp)
+		case mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_ADDPATH,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH:
+			message := rec.(*bgp4mpAddPathMessage)
+			f.processBGP4MPMessage(message.BGP4MPMessage, message.pathIDs, timestamp)
+		case mrt.BGP4MP_SUBTYPE_BGP4MP_STATE_CHANGE,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_STATE_CHANGE_AS4:
+			f.processBGP4MPStateChange(rec.(*mrt.BGP4MPStateChange), timestamp)
 		default:
-			f.logger.Trace().Msgf("unknown mrt entry type: '%v'", rec.Type())
+			f.logger.Trace().Msgf("unknown mrt entry subtype: '%v'", rec.Subtype())
 		}
+	default:
+		f.logger.Trace().Msgf("unknown mrt entry type: '%v'", rec.Type())
 	}
 }
 
+// addPeerInformation registers the peers of the peer index table. If the path does not contain a collector, the
+// collector is named after the collector BGP ID and view name of the peer index table, which also applies to the
+// updates following the table dump in a stream.
 func (f *mrtFile) addPeerInformation(peers *mrt.TableDumpV2PeerIndexTable) {
+	if getCollectorFromPath(f.name) == "" {
+		f.setCollector(getCollectorFromPeerIndexTable(peers))
+	}
+
+	// a stream may contain multiple table dumps, the RIB entries refer to the peer index table before them
+	f.peers = make([]routes.Peer, 0, len(peers.PeerEntries))
 	for _, peer := range peers.PeerEntries {
 		f.peers = append(f.peers, routes.Peer{
-			AS: peer.PeerAS.String(),
-			IP: peer.PeerIPAddress.String(),
+			AS:        peer.PeerAS.String(),
+			IP:        peer.PeerIPAddress.String(),
+			Collector: f.collector,
 		})
 	}
 
-	if len(f.wantedPeers) == 0 {
-		peersMap := make(map[string]struct{})
-		for _, peer := range f.peers {
-			peersMap[peer.AS] = struct{}{}
-		}
-		f.wantedPeers = peersMap
+	f.channels.Peers <- f.peers
+}
+
+// addPeer registers a peer which is not listed in a peer index table, e.g. the peer of a TABLE_DUMP record or the
+// sender of a BGP4MP message.
+func (f *mrtFile) addPeer(peer routes.Peer) {
+	if _, ok := f.knownPeers[peer]; ok {
+		return
 	}
+	f.knownPeers[peer] = struct{}{}
+	f.channels.Peers <- []routes.Peer{peer}
+}
 
-	f.channels.Peers <- f.peers
+func (f *mrtFile) isWantedPeer(peer routes.Peer) bool {
+	if len(f.wantedPeers) == 0 {
+		return true
+	}
+	_, ok := f.wantedPeers[peer.AS]
+	return ok
 }
 
-func (f *mrtFile) processMRTEntry(mrtEntry *mrt.TableDumpV2RIB) {
+func (f *mrtFile) processMRTEntry(mrtEntry *mrt.TableDumpV2RIB, safi mrt.SAFI) {
+	var addPath bool
+	switch mrtEntry.Subtype() {
+	case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST_ADDPATH,
+		mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST_ADDPATH,
+		mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST_ADDPATH,
+		mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST_ADDPATH:
+		addPath = true
+	}
+
 	if mrtEntry.Prefix != nil {
-		err := filterPrefix(*mrtEntry.Prefix)
+		err := f.checkPrefix(*mrtEntry.Prefix, safi)
 		if err != nil {
 			f.logger.Trace().Err(err).Str("prefix", mrtEntry.Prefix.String()).Msg("invalid prefix")
-			return
 		}
 
 		for _, ribEntry := range mrtEntry.RIBEntries {
-			if _, ok := f.wantedPeers[f.peers[ribEntry.PeerIndex].AS]; ok {
-				f.processRIBEntry(ribEntry, *mrtEntry.Prefix)
+			if int(ribEntry.PeerIndex) >= len(f.peers) {
+				f.logger.Error().
+					Str("prefix", mrtEntry.Prefix.String()).
+					Uint16("peer_index", ribEntry.PeerIndex).
+					Int("peers", len(f.peers)).
+					Msg("RIB entry refers to an unknown peer")
+				continue
+			}
+			peer := f.peers[ribEntry.PeerIndex]
+			if !f.isWantedPeer(peer) {
+				continue
 			}
+			if err != nil {
+				f.filterRoute(filterReason(err), *mrtEntry.Prefix, getPathOriginAS(ribEntry.BGPAttributes), peer, safi)
+				continue
+			}
+			f.processRIBEntry(ribEntry, peer, *mrtEntry.Prefix, safi, addPath, mrtEntry.Timestamp())
 		}
 	}
 }
 
-func (f *mrtFile) processRIBEntry(ribEntry *mrt.TableDumpV2RIBEntry, prefix net.IPNet) {
-	for _, attribute := range ribEntry.BGPAttributes {
-		switch asPath := attribute.Value.(type) {
-		case mrt.BGPPathAttributeASPath:
-			if len(asPath) == 0 {
-				f.logger.Trace().Str("prefix", prefix.String()).Msg("AS path is empty")
-				return
-			}
-			lastASPathEntry := asPath[len(asPath)-1]
-			var originAS string
-
-			switch lastASPathEntry.Type {
-			case mrt.BGPASPathSegmentTypeASSequence:
-				if len(lastASPathEntry.Value) == 0 {
-					f.logger.Trace().Str("prefix", prefix.String()).Msg("last AS path entry is empty")
-					return
-				}
-				originAS = lastASPathEntry.Value[len(lastASPathEntry.Value)-1].String()
-				asnParsed, err := strconv.Atoi(originAS)
-				if err != nil {
-					f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", originAS).Msg("ASN is not a number")
-					return
-				}
-				err = filterASN(asnParsed)
-				if err != nil {
-					f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", originAS).Msg("invalid ASN")
-					return
-				}
-			case mrt.BGPASPathSegmentTypeASSet:
-				var validASes []int
-				for _, asn := range lastASPathEntry.Value {
-					asnParsed, err := strconv.Atoi(asn.String())
-					if err != nil {
-						f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", asn.String()).Msg("ASN is not a number")
-						return
-					}
-					err = filterASN(asnParsed)
-					if err != nil {
-						f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", asn.String()).Msg("invalid ASN")
-					} else {
-						validASes = append(validASes, asnParsed)
-					}
-				}
+// processRIBEntry processes the route of a peer. If addPath is set, the peer may have multiple routes to the prefix,
+// which are distinguished by their path ID.
+func (f *mrtFile) processRIBEntry(ribEntry *mrt.TableDumpV2RIBEntry, peer routes.Peer, prefix net.IPNet, safi mrt.SAFI, addPath bool, timestamp time.Time) {
+	originAS, reason, ok := f.getOriginAS(ribEntry.BGPAttributes, prefix)
+	if !ok {
+		f.filterRoute(reason, prefix, originAS, peer, safi)
+		return
+	}
 
-				if len(validASes) == 0 {
-					originAS = "{"
-					for index, asn := range lastASPathEntry.Value {
-						originAS += asn.String()
-						if index != len(lastASPathEntry.Value)-1 {
-							originAS += ","
-						}
-					}
-					originAS += "}"
-					f.logger.Trace().Str("prefix", prefix.String()).Str("as_set", originAS).Msg("invalid AS set")
-					return
-				} else if len(validASes) == 1 {
-					originAS = strconv.Itoa(validASes[0])
-				} else {
-					sort.Ints(validASes)
-					originAS = "{"
-					for index, asn := range validASes {
-						originAS += strconv.Itoa(asn)
-						if index != len(validASes)-1 {
-							originAS += ","
-						}
-					}
-					originAS += "}"
-				}
+	f.sendAnnouncement(routes.RouteAnnouncement{
+		Type:       routes.Announce,
+		Prefix:     prefix.String(),
+		OriginAS:   originAS,
+		ReceivedBy: peer,
+		Timestamp:  timestamp,
+		Attributes: f.getRouteAttributes(ribEntry.BGPAttributes, true),
+		AddPath:    addPath,
+		PathID:     ribEntry.PathIdentifier,
+	}, prefix, safi)
+}
+
+// processTableDump processes a legacy TABLE_DUMP record, which contains the route of a single peer.
+func (f *mrtFile) processTableDump(tableDump *mrt.TableDump) {
+	if tableDump.Prefix == nil {
+		return
+	}
+
+	peer := routes.Peer{
+		AS:        tableDump.PeerAS.String(),
+		IP:        tableDump.PeerIPAddress.String(),
+		Collector: f.collector,
+	}
+	f.addPeer(peer)
+	if !f.isWantedPeer(peer) {
+		return
+	}
+
+	err := f.checkPrefix(*tableDump.Prefix, mrt.SAFIUnicast)
+	if err != nil {
+		f.logger.Trace().Err(err).Str("prefix", tableDump.Prefix.String()).Msg("invalid prefix")
+		f.filterRoute(filterReason(err), *tableDump.Prefix, getPathOriginAS(tableDump.BGPAttributes), peer, mrt.SAFIUnicast)
+		return
+	}
+
+	originAS, reason, ok := f.getOriginAS(tableDump.BGPAttributes, *tableDump.Prefix)
+	if !ok {
+		f.filterRoute(reason, *tableDump.Prefix, originAS, peer, mrt.SAFIUnicast)
+		return
+	}
+
+	f.sendAnnouncement(routes.RouteAnnouncement{
+		Type:       routes.Announce,
+		Prefix:     tableDump.Prefix.String(),
+		OriginAS:   originAS,
+		ReceivedBy: peer,
+		Timestamp:  tableDump.Timestamp(),
+		Attributes: f.getRouteAttributes(tableDump.BGPAttributes, true),
+	}, *tableDump.Prefix, mrt.SAFIUnicast)
+}
+
+// processBGP4MPMessage processes the withdrawals and announcements of a BGP update message. pathIDs contains the
+// ADD-PATH path IDs of the prefixes, it is nil if the message has no path IDs.
+func (f *mrtFile) processBGP4MPMessage(message *mrt.BGP4MPMessage, pathIDs map[*net.IPNet]uint32, timestamp time.Time) {
+	if message.BGPMessage == nil {
+		return
+	}
+	update, ok := (*message.BGPMessage).(*mrt.BGPUpdateMessage)
+	if !ok {
+		return
+	}
+
+	peer := routes.Peer{
+		AS:        message.PeerAS.String(),
+		IP:        message.PeerIPAddress.String(),
+		Collector: f.collector,
+	}
+	f.addPeer(peer)
+	if !f.isWantedPeer(peer) {
+		return
+	}
+
+	attributes := update.PathAttributes
+	if isAS4Message(message.Subtype()) {
+		attributes = withoutAS4Path(attributes)
+	}
+
+	for _, attribute := range attributes {
+		if unreach, ok := attribute.Value.(*mrt.BGPPathAttributeMPUnreachNLRI); ok {
+			f.processWithdrawals(unreach.WithdrawnRoutes, unreach.SAFI, pathIDs, peer, timestamp)
+		}
+	}
+	f.processWithdrawals(update.WithdrawnRoutes, mrt.SAFIUnicast, pathIDs, peer, timestamp)
+
+	f.processAnnouncements(update.NLRI, mrt.SAFIUnicast, false, attributes, pathIDs, peer, timestamp)
+	for _, attribute := range attributes {
+		if reach, ok := attribute.Value.(*mrt.BGPPathAttributeMPReachNLRI); ok {
+			f.processAnnouncements(reach.NLRI, reach.SAFI, true, attributes, pathIDs, peer, timestamp)
+		}
+	}
+}
+
+// isAS4Message returns true for the BGP4MP message subtypes of sessions with 4-byte ASNs.
+func isAS4Message(subtype uint16) bool {
+	switch subtype {
+	case mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4,
+		mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL,
+		mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH,
+		mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL_ADDPATH:
+		return true
+	}
+	return false
+}
+
+func (f *mrtFile) processWithdrawals(prefixes []*net.IPNet, safi mrt.SAFI, pathIDs map[*net.IPNet]uint32, peer routes.Peer, timestamp time.Time) {
+	if safi != mrt.SAFIUnicast && safi != mrt.SAFIMulticast {
+		return
+	}
+
+	for _, prefix := range prefixes {
+		err := f.checkPrefix(*prefix, safi)
+		if err != nil {
+			f.logger.Trace().Err(err).Str("prefix", prefix.String()).Msg("invalid prefix")
+			continue
+		}
+		f.sendAnnouncement(routes.RouteAnnouncement{
+			Type:       routes.Withdraw,
+			Prefix:     prefix.String(),
+			ReceivedBy: peer,
+			Timestamp:  timestamp,
+			AddPath:    pathIDs != nil,
+			PathID:     pathIDs[prefix],
+		}, *prefix, safi)
+	}
+}
+
+// processAnnouncements processes the NLRI of an update. multiprotocol is set for the NLRI of MP_REACH_NLRI.
+func (f *mrtFile) processAnnouncements(prefixes []*net.IPNet, safi mrt.SAFI, multiprotocol bool, attributes []*mrt.BGPPathAttribute, pathIDs map[*net.IPNet]uint32, peer routes.Peer, timestamp time.Time) {
+	if safi != mrt.SAFIUnicast && safi != mrt.SAFIMulticast {
+		return
+	}
+
+	routeAttributes := f.getRouteAttributes(attributes, multiprotocol)
+	for _, prefix := range prefixes {
+		err := f.checkPrefix(*prefix, safi)
+		if err != nil {
+			f.logger.Trace().Err(err).Str("prefix", prefix.String()).Msg("invalid prefix")
+			f.filterRoute(filterReason(err), *prefix, getPathOriginAS(attributes), peer, safi)
+			continue
+		}
+		originAS, reason, ok := f.getOriginAS(attributes, *prefix)
+		if !ok {
+			f.filterRoute(reason, *prefix, originAS, peer, safi)
+			continue
+		}
+		f.sendAnnouncement(routes.RouteAnnouncement{
+			Type:       routes.Announce,
+			Prefix:     prefix.String(),
+			OriginAS:   originAS,
+			ReceivedBy: peer,
+			Timestamp:  timestamp,
+			Attributes: routeAttributes,
+			AddPath:    pathIDs != nil,
+			PathID:     pathIDs[prefix],
+		}, *prefix, safi)
+	}
+}
+
+func (f *mrtFile) processBGP4MPStateChange(stateChange *mrt.BGP4MPStateChange, timestamp time.Time) {
+	if stateChange.OldState != bgpStateEstablished || stateChange.NewState == bgpStateEstablished {
+		return
+	}
+
+	peer := routes.Peer{
+		AS:        stateChange.PeerAS.String(),
+		IP:        stateChange.PeerIPAddress.String(),
+		Collector: f.collector,
+	}
+	f.addPeer(peer)
+	if !f.isWantedPeer(peer) {
+		return
+	}
+
+	f.channels.SessionResets <- peer
+
+	sessionDown := routes.RouteAnnouncement{
+		Type:       routes.SessionDown,
+		ReceivedBy: peer,
+		Timestamp:  timestamp,
+	}
+	f.batchers.ipv4.Add(sessionDown)
+	f.batchers.ipv6.Add(sessionDown)
+	f.batchers.ipv4Multicast.Add(sessionDown)
+	f.batchers.ipv6Multicast.Add(sessionDown)
+	f.batchers.ipv4TooSpecific.Add(sessionDown)
+	f.batchers.ipv6TooSpecific.Add(sessionDown)
+}
+
+// checkPrefix returns an error if the prefix is filtered or its length is out of the limits. Too specific unicast
+// prefixes are not filtered if they are analyzed separately.
+func (f *mrtFile) checkPrefix(prefix net.IPNet, safi mrt.SAFI) error {
+	err := f.filter.checkPrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	err = f.prefixLengths.check(prefix)
+	if err == errPrefixTooSpecific && f.tooSpecific && safi == mrt.SAFIUnicast {
+		return nil
+	}
+	return err
+}
+
+// sendAnnouncement passes the announcement to the view of the address family and SAFI of the prefix, so unicast and
+// multicast routes are kept apart. Too specific unicast prefixes are passed to separate views if they are analyzed.
+func (f *mrtFile) sendAnnouncement(announcement routes.RouteAnnouncement, prefix net.IPNet, safi mrt.SAFI) {
+	tooSpecific := f.tooSpecific && safi == mrt.SAFIUnicast && f.prefixLengths.check(prefix) == errPrefixTooSpecific
+	switch {
+	case prefix.IP.To4() != nil && tooSpecific:
+		f.batchers.ipv4TooSpecific.Add(announcement)
+	case tooSpecific:
+		f.batchers.ipv6TooSpecific.Add(announcement)
+	case prefix.IP.To4() != nil && safi == mrt.SAFIMulticast:
+		f.batchers.ipv4Multicast.Add(announcement)
+	case prefix.IP.To4() != nil:
+		f.batchers.ipv4.Add(announcement)
+	case safi == mrt.SAFIMulticast:
+		f.batchers.ipv6Multicast.Add(announcement)
+	default:
+		f.batchers.ipv6.Add(announcement)
+	}
+}
+
+// getOriginAS determines the origin AS from the AS path, which is reconstructed from AS_PATH and AS4_PATH for routes of
+// 2-byte ASN sessions. Confederation segments are stripped, so the origin is taken from the last AS_SEQUENCE or AS_SET
+// segment. It returns false and the reason if the origin is not usable, the origin AS is still returned if it is known.
+func (f *mrtFile) getOriginAS(attributes []*mrt.BGPPathAttribute, prefix net.IPNet) (string, string, bool) {
+	asPath, reconstructed, ok := getASPath(attributes)
+	if !ok {
+		f.statistics.ASPaths.Missing++
+		f.logger.Trace().Str("prefix", prefix.String()).Msg("AS path is missing")
+		return "", routes.FilterReasonMissingASPath, false
+	}
+	if reconstructed {
+		f.statistics.ReconstructedASPaths++
+	}
+
+	strippedASPath, ok := stripASPath(asPath, &f.statistics.ASPaths)
+	if !ok {
+		f.logger.Debug().Str("prefix", prefix.String()).Str("as_path", formatASPath(asPath)).Msg("unknown AS path segment type")
+		return "", routes.FilterReasonUnknownSegment, false
+	}
+	asPath = strippedASPath
+	if len(asPath) == 0 {
+		f.statistics.ASPaths.Empty++
+		f.logger.Trace().Str("prefix", prefix.String()).Msg("AS path is empty")
+		return "", routes.FilterReasonEmptyASPath, false
+	}
+	lastASPathEntry := asPath[len(asPath)-1]
+	var originAS string
+
+	switch lastASPathEntry.Type {
+	case mrt.BGPASPathSegmentTypeASSequence:
+		f.statistics.ASPaths.Sequence++
+		originAS = lastASPathEntry.Value[len(lastASPathEntry.Value)-1].String()
+		asnParsed, err := strconv.Atoi(originAS)
+		if err != nil {
+			f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", originAS).Msg("ASN is not a number")
+			return originAS, routes.FilterReasonInvalidASN, false
+		}
+		err = f.filter.checkASN(asnParsed)
+		if err != nil {
+			f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", originAS).Msg("invalid ASN")
+			return originAS, filterReason(err), false
+		}
+	case mrt.BGPASPathSegmentTypeASSet:
+		f.statistics.ASPaths.TrailingASSet++
+		var validASes []int
+		for _, asn := range lastASPathEntry.Value {
+			asnParsed, err := strconv.Atoi(asn.String())
+			if err != nil {
+				f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", asn.String()).Msg("ASN is not a number")
+				return formatASPath(mrt.BGPPathAttributeASPath{lastASPathEntry}), routes.FilterReasonInvalidASN, false
+			}
+			err = f.filter.checkASN(asnParsed)
+			if err != nil {
+				f.logger.Trace().Err(err).Str("prefix", prefix.String()).Str("asn", asn.String()).Msg("invalid ASN")
+			} else {
+				validASes = append(validASes, asnParsed)
 			}
+		}
 
-			if prefix.IP.To4() != nil {
-				f.channels.IPv4 <- routes.RouteAnnouncement{
-					Prefix:     prefix.String(),
-					OriginAS:   originAS,
-					ReceivedBy: f.peers[ribEntry.PeerIndex],
+		if len(validASes) == 0 {
+			originAS = "{"
+			for index, asn := range lastASPathEntry.Value {
+				originAS += asn.String()
+				if index != len(lastASPathEntry.Value)-1 {
+					originAS += ","
 				}
-			} else {
-				f.channels.IPv6 <- routes.RouteAnnouncement{
-					Prefix:     prefix.String(),
-					OriginAS:   originAS,
-					ReceivedBy: f.peers[ribEntry.PeerIndex],
+			}
+			originAS += "}"
+			f.logger.Trace().Str("prefix", prefix.String()).Str("as_set", originAS).Msg("invalid AS set")
+			return originAS, routes.FilterReasonInvalidASSet, false
+		} else if len(validASes) == 1 {
+			originAS = strconv.Itoa(validASes[0])
+		} else {
+			sort.Ints(validASes)
+			originAS = "{"
+			for index, asn := range validASes {
+				originAS += strconv.Itoa(asn)
+				if index != len(validASes)-1 {
+					originAS += ","
 				}
 			}
-			return
+			originAS += "}"
 		}
 	}
+
+	return originAS, "", true
+}
+
+// filterRoute records an announced route which is not processed for the report of the filtered routes.
+func (f *mrtFile) filterRoute(reason string, prefix net.IPNet, originAS string, peer routes.Peer, safi mrt.SAFI) {
+	if f.statistics.Filtered == nil {
+		f.statistics.Filtered = make(map[string]int)
+	}
+	f.statistics.Filtered[reason]++
+
+	if f.filtered == nil {
+		f.filtered = make(map[routes.FilteredAnnouncement]struct{})
+	}
+	f.filtered[routes.FilteredAnnouncement{
+		Reason:     reason,
+		Prefix:     prefix.String(),
+		OriginAS:   originAS,
+		ReceivedBy: peer,
+		Multicast:  safi == mrt.SAFIMulticast,
+	}] = struct{}{}
 }
@@ -0,0 +1,560 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// processData processes the MRT records of a file and returns the announcements sent to the views ("ipv4", "ipv6",
+// "ipv4Multicast" and "ipv6Multicast") in the order of the file and the statistics of the file.
+func processData(data []byte, config Config) (map[string][]routes.RouteAnnouncement, routes.FileStatistics) {
+	channels := routes.NewChannels(1)
+	channels.Peers = make(chan []routes.Peer, 64)
+	channels.SessionResets = make(chan routes.Peer, 64)
+	channels.Files = make(chan routes.FileStatistics, 1)
+	channels.Filtered = make(chan []routes.FilteredAnnouncement, 1)
+	channels.Errors = make(chan error, 1)
+
+	newMRTFile(source{
+		name: "updates.20200913.1200",
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}, channels, config, getWantedPeers(config)).process()
+	channels.Close()
+
+	announcements := make(map[string][]routes.RouteAnnouncement)
+	for name, view := range map[string][]chan []routes.RouteAnnouncement{
+		"ipv4":          channels.IPv4,
+		"ipv6":          channels.IPv6,
+		"ipv4Multicast": channels.IPv4Multicast,
+		"ipv6Multicast": channels.IPv6Multicast,
+	} {
+		for batch := range view[0] {
+			announcements[name] = append(announcements[name], batch...)
+		}
+	}
+	return announcements, <-channels.Files
+}
+
+func TestProcessBGP4MPMessage_AS4Path(t *testing.T) {
+	peer := testPeer{as: 3333, ip: "192.0.2.1"}
+	attributes := concat(
+		originAttribute(),
+		asPathAttribute(false, asSequenceSegment(3333, 23456)),
+		as4PathAttribute(asSequenceSegment(196608)),
+		nextHopAttribute(),
+	)
+	update := bgpUpdate(nil, attributes, encodeNLRI("193.0.0.0/21"))
+
+	// the AS4_PATH of a 2-byte ASN session replaces AS_TRANS
+	announcements, statistics := processData(bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE, peer, update), Config{})
+	if assert.Len(t, announcements["ipv4"], 1) {
+		assert.Equal(t, "196608", announcements["ipv4"][0].OriginAS)
+	}
+	assert.Equal(t, 1, statistics.ReconstructedASPaths)
+
+	// the AS4_PATH of a 4-byte ASN session is ignored, so AS_TRANS is the origin, which is reserved
+	attributes = concat(
+		originAttribute(),
+		asPathAttribute(true, asSequenceSegment(3333, 23456)),
+		as4PathAttribute(asSequenceSegment(196608)),
+		nextHopAttribute(),
+	)
+	update = bgpUpdate(nil, attributes, encodeNLRI("193.0.0.0/21"))
+	announcements, statistics = processData(bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peer, update), Config{})
+	assert.Empty(t, announcements["ipv4"])
+	assert.Equal(t, map[string]int{routes.FilterReasonReservedASN: 1}, statistics.Filtered)
+	assert.Equal(t, 0, statistics.ReconstructedASPaths)
+}
+
+// failingReader returns the data and then the error.
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// processFailingFile processes a file which returns the data and then the error, and returns its statistics and the
+// error passed on.
+func processFailingFile(data []byte, err error, config Config) (routes.FileStatistics, error) {
+	channels := routes.Channels{
+		Peers:  make(chan []routes.Peer, 2),
+		Files:  make(chan routes.FileStatistics, 1),
+		Errors: make(chan error, 1),
+	}
+	newMRTFile(source{
+		name: "bview.20200913.1200",
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(&failingReader{data: data, err: err}), nil
+		},
+	}, channels, config, nil).process()
+	close(channels.Errors)
+	return <-channels.Files, <-channels.Errors
+}
+
+func TestMRTFile_Process_ReadError(t *testing.T) {
+	data := concat(emptyPeerIndexTable(), emptyPeerIndexTable())
+	readErr := errors.New("input/output error")
+
+	statistics, err := processFailingFile(data, readErr, Config{})
+	assert.ErrorIs(t, err, readErr)
+	assert.Equal(t, 2, statistics.Records)
+	assert.Equal(t, int64(len(data)), statistics.Bytes)
+	assert.Contains(t, statistics.Error, readErr.Error())
+	assert.False(t, statistics.Truncated)
+
+	statistics, err = processFailingFile(data, readErr, Config{ContinueOnError: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, statistics.Records)
+	assert.Contains(t, statistics.Error, readErr.Error())
+}
+
+func TestMRTFile_Process_Truncated(t *testing.T) {
+	data := concat(emptyPeerIndexTable(), emptyPeerIndexTable())
+
+	statistics, err := processFailingFile(data[:len(data)-3], io.EOF, Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, statistics.Records)
+	assert.Empty(t, statistics.Error)
+	assert.True(t, statistics.Truncated)
+}
+
+func TestProcessSources_Abort(t *testing.T) {
+	readErr := errors.New("input/output error")
+	run := func(config Config) (int, []routes.FileStatistics, error) {
+		var opens int
+		sources := []source{{
+			name: "rrc00/bview.20200913.1200",
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(&failingReader{data: emptyPeerIndexTable(), err: readErr}), nil
+			},
+		}, {
+			name: "rrc01/bview.20200913.1200",
+			open: func() (io.ReadCloser, error) {
+				opens++
+				return io.NopCloser(bytes.NewReader(emptyPeerIndexTable())), nil
+			},
+		}}
+
+		channels := routes.NewChannels(1)
+		files := make(chan []routes.FileStatistics, 1)
+		go func() {
+			var statistics []routes.FileStatistics
+			for file := range channels.Files {
+				statistics = append(statistics, file)
+			}
+			files <- statistics
+		}()
+		errs := make(chan error, 1)
+		go func() {
+			var err error
+			for e := range channels.Errors {
+				err = e
+			}
+			errs <- err
+		}()
+		go func() {
+			for range channels.Peers {
+			}
+		}()
+
+		processSources(sources, channels, withAbortSignal(config), SnapshotSelection{})
+		channels.Close()
+		return opens, <-files, <-errs
+	}
+
+	// the second file is not processed after the first one failed
+	opens, statistics, err := run(Config{Workers: 1})
+	assert.ErrorIs(t, err, readErr)
+	assert.Equal(t, 0, opens)
+	if assert.Len(t, statistics, 1) {
+		assert.Equal(t, "rrc00/bview.20200913.1200", statistics[0].Name)
+	}
+
+	opens, statistics, err = run(Config{Workers: 1, ContinueOnError: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, opens)
+	assert.Len(t, statistics, 2)
+}
+
+// trackedFile decrements the number of open files when it is closed.
+type trackedFile struct {
+	io.Reader
+	mutex *sync.Mutex
+	open  *int
+}
+
+func (f *trackedFile) Read(p []byte) (int, error) {
+	// keep the file open long enough for the other workers to open their files
+	time.Sleep(5 * time.Millisecond)
+	return f.Reader.Read(p)
+}
+
+func (f *trackedFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	*f.open--
+	return nil
+}
+
+func TestProcessSources_Workers(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		var mutex sync.Mutex
+		var open, maxOpen int
+		var sources []source
+		for i := 0; i < 8; i++ {
+			sources = append(sources, source{
+				name: fmt.Sprintf("rrc%02d/bview.20200913.1200", i),
+				open: func() (io.ReadCloser, error) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					open++
+					if open > maxOpen {
+						maxOpen = open
+					}
+					return &trackedFile{Reader: bytes.NewReader(emptyPeerIndexTable()), mutex: &mutex, open: &open}, nil
+				},
+			})
+		}
+
+		config := Config{Workers: workers}
+		directory := detectMOAS(t, func(channels routes.Channels) {
+			processSources(sources, channels, withAbortSignal(config), SnapshotSelection{})
+			channels.Close()
+		}, config)
+
+		// the workers limit the number of files which are open at the same time
+		assert.Equal(t, workers, maxOpen)
+		assert.Equal(t, 0, open)
+
+		var statistics routes.Statistics
+		readOutput(t, directory, "statistics.json", &statistics)
+		if assert.Len(t, statistics.Files, len(sources)) {
+			for _, file := range statistics.Files {
+				assert.Equal(t, int64(len(emptyPeerIndexTable())), file.Bytes, file.Name)
+				assert.Greater(t, file.Duration, 0.0, file.Name)
+				assert.InDelta(t, float64(file.Bytes)/file.Duration, file.Throughput, 1e-6, file.Name)
+			}
+		}
+	}
+}
+
+func TestProcessMRTEntry_RIBGeneric(t *testing.T) {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}, {as: 174, ip: "2001:db8::2"}}
+	attributes := func(origin uint32) []byte {
+		return concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333, origin)), nextHopAttribute())
+	}
+	data := concat(
+		peerIndexTable(testTime, peers...),
+		ribGenericRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC, mrt.AFIIPv4, mrt.SAFIUnicast, "193.0.0.0/21",
+			testRIBEntry{peerIndex: 0, attributes: attributes(1103)}),
+		ribGenericRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH, mrt.AFIIPv6, mrt.SAFIMulticast, "2001:67c:2e8::/48",
+			testRIBEntry{peerIndex: 1, pathID: 7, attributes: attributes(3333)}),
+		// MPLS-labeled VPN routes are not supported
+		ribGenericRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC, mrt.AFIIPv4, 128, "193.0.0.0/21",
+			testRIBEntry{peerIndex: 0, attributes: attributes(13335)}),
+	)
+
+	announcements, statistics := processData(data, Config{})
+	assert.Equal(t, 4, statistics.Records)
+	assert.Equal(t, 3, statistics.RIBRecords)
+	assert.Equal(t, map[string][]routes.RouteAnnouncement{
+		"ipv4": {{
+			Type:       routes.Announce,
+			Prefix:     "193.0.0.0/21",
+			OriginAS:   "1103",
+			ReceivedBy: routes.Peer{AS: "3333", IP: "192.0.2.1", Collector: "192.0.2.254"},
+			Timestamp:  time.Unix(testTime, 0).UTC(),
+		}},
+		"ipv6Multicast": {{
+			Type:       routes.Announce,
+			Prefix:     "2001:67c:2e8::/48",
+			OriginAS:   "3333",
+			ReceivedBy: routes.Peer{AS: "174", IP: "2001:db8::2", Collector: "192.0.2.254"},
+			Timestamp:  time.Unix(testTime, 0).UTC(),
+			AddPath:    true,
+			PathID:     7,
+		}},
+	}, announcements)
+}
+
+func TestProcessMRTEntry_PeerIndexTables(t *testing.T) {
+	attributes := concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333, 1103)), nextHopAttribute())
+	data := concat(
+		// a RIB record before any peer index table is skipped
+		ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+			testRIBEntry{peerIndex: 0, attributes: attributes}),
+		collectorPeerIndexTable(testTime, "192.0.2.253", testPeer{as: 3333, ip: "192.0.2.1"}),
+		ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 1, "193.0.0.0/21",
+			testRIBEntry{peerIndex: 0, attributes: attributes},
+			testRIBEntry{peerIndex: 1, attributes: attributes}),
+		// the RIB entries of a second table dump in the same stream refer to its own peer index table and collector
+		collectorPeerIndexTable(testTime+3600, "192.0.2.254", testPeer{as: 174, ip: "192.0.2.2"}),
+		ribRecord(testTime+3600, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+			testRIBEntry{peerIndex: 0, attributes: attributes}),
+	)
+
+	announcements, statistics := processData(data, Config{})
+	assert.Equal(t, 5, statistics.Records)
+	assert.Equal(t, "192.0.2.254", statistics.Collector)
+	assert.Equal(t, map[string][]routes.RouteAnnouncement{
+		"ipv4": {{
+			Type:       routes.Announce,
+			Prefix:     "193.0.0.0/21",
+			OriginAS:   "1103",
+			ReceivedBy: routes.Peer{AS: "3333", IP: "192.0.2.1", Collector: "192.0.2.253"},
+			Timestamp:  time.Unix(testTime, 0).UTC(),
+		}, {
+			Type:       routes.Announce,
+			Prefix:     "193.0.0.0/21",
+			OriginAS:   "1103",
+			ReceivedBy: routes.Peer{AS: "174", IP: "192.0.2.2", Collector: "192.0.2.254"},
+			Timestamp:  time.Unix(testTime+3600, 0).UTC(),
+		}},
+	}, announcements)
+}
+
+func TestProcessBGP4MPMessage(t *testing.T) {
+	peer := testPeer{as: 3333, ip: "192.0.2.1"}
+	peer6 := testPeer{as: 174, ip: "2001:db8::2"}
+	data := concat(
+		bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE, peer, bgpUpdate(nil,
+			concat(originAttribute(), asPathAttribute(false, asSequenceSegment(3333, 1103)), nextHopAttribute()),
+			encodeNLRI("193.0.0.0/21"))),
+		bgp4mpMessage(testTime+1, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peer, bgpUpdate(nil,
+			concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333, 196608)), nextHopAttribute()),
+			encodeNLRI("193.0.0.0/21"))),
+		bgp4mpETMessage(testTime+2, 500000, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peer, bgpUpdate(
+			encodeNLRI("193.0.0.0/21"), nil, nil)),
+		bgp4mpMessage(testTime+3, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peer6, bgpUpdate(nil,
+			concat(originAttribute(), asPathAttribute(true, asSequenceSegment(174, 3333)),
+				mpReachAttribute(encodeNLRI("2001:67c:2e8::/48"))), nil)),
+		bgp4mpETMessage(testTime+4, 1, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peer6, bgpUpdate(nil,
+			mpUnreachAttribute(encodeNLRI("2001:67c:2e8::/48")), nil)),
+	)
+
+	announcements, statistics := processData(data, Config{})
+	assert.Equal(t, 5, statistics.Records)
+	assert.Empty(t, statistics.Error)
+	assert.False(t, statistics.Truncated)
+	if assert.NotNil(t, statistics.ObservationEnd) {
+		assert.Equal(t, time.Unix(testTime+4, 1000).UTC(), *statistics.ObservationEnd)
+	}
+
+	routesPeer := routes.Peer{AS: "3333", IP: "192.0.2.1"}
+	routesPeer6 := routes.Peer{AS: "174", IP: "2001:db8::2"}
+	assert.Equal(t, map[string][]routes.RouteAnnouncement{
+		"ipv4": {
+			{
+				Type:       routes.Announce,
+				Prefix:     "193.0.0.0/21",
+				OriginAS:   "1103",
+				ReceivedBy: routesPeer,
+				Timestamp:  time.Unix(testTime, 0).UTC(),
+			},
+			{
+				Type:       routes.Announce,
+				Prefix:     "193.0.0.0/21",
+				OriginAS:   "196608",
+				ReceivedBy: routesPeer,
+				Timestamp:  time.Unix(testTime+1, 0).UTC(),
+			},
+			{
+				Type:       routes.Withdraw,
+				Prefix:     "193.0.0.0/21",
+				ReceivedBy: routesPeer,
+				Timestamp:  time.Unix(testTime+2, 500000000).UTC(),
+			},
+		},
+		"ipv6": {
+			{
+				Type:       routes.Announce,
+				Prefix:     "2001:67c:2e8::/48",
+				OriginAS:   "3333",
+				ReceivedBy: routesPeer6,
+				Timestamp:  time.Unix(testTime+3, 0).UTC(),
+			},
+			{
+				Type:       routes.Withdraw,
+				Prefix:     "2001:67c:2e8::/48",
+				ReceivedBy: routesPeer6,
+				Timestamp:  time.Unix(testTime+4, 1000).UTC(),
+			},
+		},
+	}, announcements)
+}
+
+func TestProcessFS_UpdateFiles(t *testing.T) {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}, {as: 174, ip: "192.0.2.2"}}
+	updates := concat(
+		bgp4mpMessage(testTime+60, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peers[1], bgpUpdate(nil,
+			concat(originAttribute(), asPathAttribute(true, asSequenceSegment(174, 13335)), nextHopAttribute()),
+			encodeNLRI("193.0.0.0/21"))),
+		// withdrawals of filtered prefixes are skipped
+		bgp4mpETMessage(testTime+120, 0, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peers[1], bgpUpdate(
+			concat(encodeNLRI("193.0.0.0/21"), encodeNLRI("10.0.0.0/8")), nil, nil)),
+	)
+
+	// without replay, the origins of the update files are added to the table dump and are not removed by withdrawals
+	directory := detectMOASInFS(t, fstest.MapFS{
+		"rrc00/bview.20200913.1200": {Data: concat(
+			peerIndexTable(testTime, peers...),
+			ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+				testRIBEntry{peerIndex: 0, attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333)), nextHopAttribute())}),
+		)},
+		"rrc00/updates.20200913.1200": {Data: updates},
+	}, Config{})
+
+	var moas []routes.MOASPrefix
+	readOutput(t, directory, "moasIPv4.json", &moas)
+	if assert.Len(t, moas, 1) && assert.Len(t, moas[0].Origin, 2) {
+		assert.Equal(t, "193.0.0.0/21", moas[0].Prefix)
+		origins := map[string][]routes.Peer{}
+		for _, origin := range moas[0].Origin {
+			origins[origin.AS] = origin.Visibility
+		}
+		assert.Equal(t, map[string][]routes.Peer{
+			"13335": {{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}},
+			"3333":  {{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}},
+		}, origins)
+	}
+
+	var statistics routes.Statistics
+	readOutput(t, directory, "statistics.json", &statistics)
+	assert.Equal(t, 2, statistics.IPv4Announcements)
+	assert.Equal(t, 1, statistics.IPv4Withdrawals)
+}
+
+func TestProcessBGP4MPStateChange_ExtendedTimestamp(t *testing.T) {
+	peer := testPeer{as: 3333, ip: "192.0.2.1"}
+	stateChange := bgp4mpStateChange(testTime, peer, bgpStateEstablished, 1)
+	var microseconds bytes.Buffer
+	putUint32(&microseconds, 250)
+	data := mrtRecordAt(testTime, mrt.TYPE_BGP4MP_ET, mrt.BGP4MP_SUBTYPE_BGP4MP_STATE_CHANGE_AS4,
+		concat(microseconds.Bytes(), stateChange[mrtHeaderLength:]))
+
+	announcements, _ := processData(data, Config{})
+	if assert.Len(t, announcements["ipv4"], 1) {
+		assert.Equal(t, routes.SessionDown, announcements["ipv4"][0].Type)
+		assert.Equal(t, time.Unix(testTime, 250000).UTC(), announcements["ipv4"][0].Timestamp)
+	}
+}
+
+func TestProcessBGP4MPMessage_AddPath(t *testing.T) {
+	peer := testPeer{as: 3333, ip: "192.0.2.1"}
+	attributes := concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333, 1103)), nextHopAttribute())
+	data := concat(
+		bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH, peer, bgpUpdate(nil,
+			concat(attributes, mpReachAttribute(encodeAddPathNLRI(2, "2001:67c:2e8::/48"))),
+			encodeAddPathNLRI(1, "193.0.0.0/21"))),
+		bgp4mpMessage(testTime+1, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH, peer, bgpUpdate(
+			encodeAddPathNLRI(1, "193.0.0.0/21"), nil, nil)),
+		// messages sent by the collector are ignored
+		bgp4mpMessage(testTime+2, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL_ADDPATH, peer, bgpUpdate(nil,
+			attributes, encodeAddPathNLRI(3, "193.0.0.0/21"))),
+	)
+
+	announcements, statistics := processData(data, Config{})
+	assert.Equal(t, 3, statistics.Records)
+	routesPeer := routes.Peer{AS: "3333", IP: "192.0.2.1"}
+	assert.Equal(t, map[string][]routes.RouteAnnouncement{
+		"ipv4": {
+			{
+				Type:       routes.Announce,
+				Prefix:     "193.0.0.0/21",
+				OriginAS:   "1103",
+				ReceivedBy: routesPeer,
+				Timestamp:  time.Unix(testTime, 0).UTC(),
+				AddPath:    true,
+				PathID:     1,
+			},
+			{
+				Type:       routes.Withdraw,
+				Prefix:     "193.0.0.0/21",
+				ReceivedBy: routesPeer,
+				Timestamp:  time.Unix(testTime+1, 0).UTC(),
+				AddPath:    true,
+				PathID:     1,
+			},
+		},
+		"ipv6": {{
+			Type:       routes.Announce,
+			Prefix:     "2001:67c:2e8::/48",
+			OriginAS:   "1103",
+			ReceivedBy: routesPeer,
+			Timestamp:  time.Unix(testTime, 0).UTC(),
+			AddPath:    true,
+			PathID:     2,
+		}},
+	}, announcements)
+}
+
+// BenchmarkProcessFS measures the whole ingestion of table dumps, from reading the files to the routing table, with a
+// single worker and shard and with one per CPU, like the command uses them.
+func BenchmarkProcessFS(b *testing.B) {
+	fsys := generateTableDumps(8, 10000)
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				channels := routes.NewChannels(workers)
+				go ProcessFS(fsys, channels, Config{Workers: workers})
+
+				r := routes.NewRoutes(false, nil, false)
+				if err := r.HandleAnnouncements(channels); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// generateTableDumps generates the table dumps of the collectors with routes of 4 peers to each prefix. Half of the
+// prefixes are announced by two origin ASes.
+func generateTableDumps(collectors, prefixes int) fstest.MapFS {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}, {as: 174, ip: "192.0.2.2"}, {as: 1299, ip: "192.0.2.3"}, {as: 6939, ip: "192.0.2.4"}}
+
+	fsys := make(fstest.MapFS)
+	for c := 0; c < collectors; c++ {
+		records := [][]byte{peerIndexTable(testTime, peers...)}
+		for p := 0; p < prefixes; p++ {
+			var entries []testRIBEntry
+			for i, peer := range peers {
+				origin := uint32(1103)
+				if p%2 == 0 && i%2 == 0 {
+					origin = 13335
+				}
+				entries = append(entries, testRIBEntry{
+					peerIndex:  uint16(i),
+					attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(peer.as, origin)), nextHopAttribute()),
+				})
+			}
+			records = append(records, ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, uint32(p), fmt.Sprintf("44.%d.%d.0/24", p/256, p%256), entries...))
+		}
+		fsys[fmt.Sprintf("rrc%02d/bview.20200913.1200", c)] = &fstest.MapFile{Data: concat(records...)}
+	}
+	return fsys
+}
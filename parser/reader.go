@@ -0,0 +1,433 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/TheFireMike/go-mrt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+const mrtHeaderLength = 12
+
+// recordError is returned for a record which could not be decoded. In contrast to an error of the underlying stream,
+// the reader can continue with the next record.
+type recordError struct {
+	err error
+}
+
+func (e recordError) Error() string {
+	return e.err.Error()
+}
+
+// extendedTimestampRecord is a BGP4MP_ET record with the microseconds of its timestamp, which the mrt package drops.
+type extendedTimestampRecord struct {
+	mrt.Record
+	timestamp time.Time
+}
+
+func (r *extendedTimestampRecord) Timestamp() time.Time {
+	return r.timestamp
+}
+
+// bgp4mpAddPathMessage is a BGP4MP message of one of the ADDPATH subtypes with the path IDs of its prefixes
+// (RFC 8050), which the mrt package does not decode. The path IDs of the withdrawn routes and NLRI of the BGP update
+// message and of its MP_REACH_NLRI and MP_UNREACH_NLRI attributes are looked up by the decoded prefix.
+type bgp4mpAddPathMessage struct {
+	*mrt.BGP4MPMessage
+	pathIDs map[*net.IPNet]uint32
+}
+
+// recordReader reads MRT records like mrt.Reader, but works around records which the mrt package does not decode
+// correctly.
+type recordReader struct {
+	reader io.Reader
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{
+		reader: r,
+	}
+}
+
+// Next returns the next record. It returns io.EOF at the end of the stream and a recordError if only the record could
+// not be decoded. Any other error is an error of the stream, e.g. io.ErrUnexpectedEOF for a truncated stream.
+func (r *recordReader) Next() (record mrt.Record, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = recordError{errors.New("parsing failed: " + fmt.Sprint(e))}
+		}
+	}()
+
+	// the path IDs of BGP4MP messages with ADD-PATH NLRI
+	var pathIDs []uint32
+
+	data := make([]byte, mrtHeaderLength)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, err
+	}
+
+	hdrType := mrt.RecordType(binary.BigEndian.Uint16(data[4:]))
+	hdrSubtype := binary.BigEndian.Uint16(data[6:])
+	hdrLength := binary.BigEndian.Uint32(data[8:])
+
+	data = append(data, make([]byte, hdrLength)...)
+	if _, err := io.ReadFull(r.reader, data[mrtHeaderLength:]); err != nil {
+		if err == io.EOF {
+			// the stream ended after the header
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch hdrType {
+	case mrt.TYPE_OSPFv2:
+		record = new(mrt.OSPFv2)
+	case mrt.TYPE_TABLE_DUMP:
+		switch hdrSubtype {
+		case mrt.TABLE_DUMP_SUBTYPE_AFI_IPv4, mrt.TABLE_DUMP_SUBTYPE_AFI_IPv6:
+			record = new(mrt.TableDump)
+			if data, err = stripTableDumpAttributeLength(data, hdrSubtype); err != nil {
+				return nil, recordError{err}
+			}
+		default:
+			return nil, recordError{fmt.Errorf("unknown MRT record subtype: %d", hdrSubtype)}
+		}
+	case mrt.TYPE_TABLE_DUMP_V2:
+		switch hdrSubtype {
+		case mrt.TABLE_DUMP_V2_SUBTYPE_PEER_INDEX_TABLE:
+			record = new(mrt.TableDumpV2PeerIndexTable)
+		case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST_ADDPATH,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST_ADDPATH,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST_ADDPATH,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST_ADDPATH:
+			record = new(mrt.TableDumpV2RIB)
+		case mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC,
+			mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH:
+			var ok bool
+			if data, ok = convertRIBGeneric(data, hdrSubtype); ok {
+				record = new(mrt.TableDumpV2RIB)
+			} else {
+				record = new(mrt.TableDumpV2RIBGeneric)
+			}
+		default:
+			return nil, recordError{fmt.Errorf("unknown MRT record subtype: %d", hdrSubtype)}
+		}
+	case mrt.TYPE_BGP4MP, mrt.TYPE_BGP4MP_ET:
+		switch hdrSubtype {
+		case mrt.BGP4MP_SUBTYPE_BGP4MP_STATE_CHANGE, mrt.BGP4MP_SUBTYPE_BGP4MP_STATE_CHANGE_AS4:
+			record = new(mrt.BGP4MPStateChange)
+		case mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_LOCAL,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL:
+			record = new(mrt.BGP4MPMessage)
+		case mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_ADDPATH,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_LOCAL_ADDPATH,
+			mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_LOCAL_ADDPATH:
+			record = new(mrt.BGP4MPMessage)
+			if data, pathIDs, err = stripAddPathIDs(data, hdrType, hdrSubtype); err != nil {
+				return nil, recordError{err}
+			}
+		default:
+			return nil, recordError{fmt.Errorf("unknown MRT record subtype: %d", hdrSubtype)}
+		}
+	case mrt.TYPE_ISIS, mrt.TYPE_ISIS_ET:
+		record = new(mrt.ISIS)
+	case mrt.TYPE_OSPFv3, mrt.TYPE_OSPFv3_ET:
+		record = new(mrt.OSPFv3)
+	default:
+		return nil, recordError{fmt.Errorf("unknown MRT record type: %d", hdrType)}
+	}
+
+	if err := record.DecodeBytes(data); err != nil {
+		return nil, recordError{err}
+	}
+
+	if pathIDs != nil {
+		if record, err = newBGP4MPAddPathMessage(record.(*mrt.BGP4MPMessage), pathIDs); err != nil {
+			return nil, recordError{err}
+		}
+	}
+
+	if hdrType == mrt.TYPE_BGP4MP_ET {
+		microseconds := binary.BigEndian.Uint32(data[mrtHeaderLength:])
+		record = &extendedTimestampRecord{
+			Record:    record,
+			timestamp: record.Timestamp().Add(time.Duration(microseconds) * time.Microsecond),
+		}
+	}
+
+	return record, nil
+}
+
+// stripTableDumpAttributeLength removes the attribute length field of a TABLE_DUMP record, which is not expected by
+// mrt.TableDump, and cuts the attributes to this length. It returns an error if the record is too short for the
+// attribute length.
+func stripTableDumpAttributeLength(data []byte, subtype uint16) ([]byte, error) {
+	ipLength := net.IPv4len
+	if subtype == mrt.TABLE_DUMP_SUBTYPE_AFI_IPv6 {
+		ipLength = net.IPv6len
+	}
+
+	// view number (2), sequence number (2), prefix, prefix length (1), status (1), originated time (4), peer IP
+	// address, peer AS (2)
+	offset := mrtHeaderLength + 2 + 2 + ipLength + 1 + 1 + 4 + ipLength + 2
+	if len(data) < offset+2 {
+		return nil, errors.Errorf("TABLE_DUMP record of %d bytes is too short", len(data)-mrtHeaderLength)
+	}
+	attributeLength := int(binary.BigEndian.Uint16(data[offset:]))
+	if len(data) < offset+2+attributeLength {
+		return nil, errors.Errorf("attribute length of %d bytes exceeds the TABLE_DUMP record", attributeLength)
+	}
+
+	strippedData := append(data[:offset:offset], data[offset+2:offset+2+attributeLength]...)
+	binary.BigEndian.PutUint32(strippedData[8:], uint32(len(strippedData)-mrtHeaderLength))
+	return strippedData, nil
+}
+
+// convertRIBGeneric converts a RIB_GENERIC record for IPv4 or IPv6 unicast or multicast routes into the corresponding
+// AFI/SAFI-specific record, as mrt.TableDumpV2RIBGeneric does not decode the NLRI and RIB entries. For these SAFIs,
+// the NLRI is encoded in the same way as the prefix of the AFI/SAFI-specific records. It returns false if the
+// AFI/SAFI is not supported.
+func convertRIBGeneric(data []byte, subtype uint16) ([]byte, bool) {
+	// sequence number (4), AFI (2), SAFI (1)
+	offset := mrtHeaderLength + 4
+	if len(data) < offset+3 {
+		return data, false
+	}
+	afi := mrt.AFI(binary.BigEndian.Uint16(data[offset:]))
+	safi := mrt.SAFI(data[offset+2])
+
+	var converted uint16
+	switch {
+	case afi == mrt.AFIIPv4 && safi == mrt.SAFIUnicast:
+		converted = mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST
+	case afi == mrt.AFIIPv4 && safi == mrt.SAFIMulticast:
+		converted = mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST
+	case afi == mrt.AFIIPv6 && safi == mrt.SAFIUnicast:
+		converted = mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST
+	case afi == mrt.AFIIPv6 && safi == mrt.SAFIMulticast:
+		converted = mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST
+	default:
+		return data, false
+	}
+	if subtype == mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH {
+		// the ADDPATH subtypes are numbered in the same order with an offset of 6
+		converted += mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST_ADDPATH - mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST
+	}
+
+	convertedData := append(data[:offset:offset], data[offset+3:]...)
+	binary.BigEndian.PutUint16(convertedData[6:], converted)
+	binary.BigEndian.PutUint32(convertedData[8:], uint32(len(convertedData)-mrtHeaderLength))
+	return convertedData, true
+}
+
+// stripAddPathIDs removes the path IDs of the prefixes of a BGP4MP update message of one of the ADDPATH subtypes, as
+// the mrt package decodes the prefixes like the prefixes of the other subtypes. The path IDs are returned in the order
+// in which the mrt package decodes the prefixes: withdrawn routes, the prefixes of the MP_REACH_NLRI and
+// MP_UNREACH_NLRI attributes and NLRI. The attributes are only converted for IPv4 and IPv6 unicast and multicast
+// routes.
+func stripAddPathIDs(data []byte, recordType mrt.RecordType, subtype uint16) ([]byte, []uint32, error) {
+	offset := mrtHeaderLength
+	if recordType == mrt.TYPE_BGP4MP_ET {
+		// microsecond timestamp (4)
+		offset += 4
+	}
+	asLength := 2
+	if isAS4Message(subtype) {
+		asLength = 4
+	}
+	// peer AS, local AS, interface index (2)
+	offset += 2*asLength + 2
+	if len(data) < offset+2 {
+		return nil, nil, errors.Errorf("BGP4MP record of %d bytes is too short", len(data)-mrtHeaderLength)
+	}
+	ipLength := net.IPv6len
+	if mrt.AFI(binary.BigEndian.Uint16(data[offset:])) == mrt.AFIIPv4 {
+		ipLength = net.IPv4len
+	}
+	// AFI (2), peer IP address, local IP address
+	message := offset + 2 + 2*ipLength
+	// marker (16), length (2), type (1)
+	offset = message + 16 + 2 + 1
+	pathIDs := make([]uint32, 0)
+	if len(data) < offset || data[offset-1] != bgpMessageTypeUpdate {
+		return data, pathIDs, nil
+	}
+
+	withdrawn, rest, err := splitLengthField(data[offset:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid withdrawn routes")
+	}
+	attributes, nlri, err := splitLengthField(rest)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid path attributes")
+	}
+
+	if withdrawn, pathIDs, err = stripNLRIPathIDs(withdrawn, pathIDs); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid withdrawn routes")
+	}
+	if attributes, pathIDs, err = stripAttributePathIDs(attributes, pathIDs); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid path attributes")
+	}
+	if nlri, pathIDs, err = stripNLRIPathIDs(nlri, pathIDs); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid NLRI")
+	}
+
+	strippedData := append([]byte(nil), data[:offset]...)
+	strippedData = appendUint16(strippedData, uint16(len(withdrawn)))
+	strippedData = append(strippedData, withdrawn...)
+	strippedData = appendUint16(strippedData, uint16(len(attributes)))
+	strippedData = append(strippedData, attributes...)
+	strippedData = append(strippedData, nlri...)
+	binary.BigEndian.PutUint16(strippedData[message+16:], uint16(len(strippedData)-message))
+	binary.BigEndian.PutUint32(strippedData[8:], uint32(len(strippedData)-mrtHeaderLength))
+	return strippedData, pathIDs, nil
+}
+
+// stripAttributePathIDs removes the path IDs of the prefixes of the MP_REACH_NLRI and MP_UNREACH_NLRI attributes and
+// appends them to pathIDs.
+func stripAttributePathIDs(attributes []byte, pathIDs []uint32) ([]byte, []uint32, error) {
+	var stripped []byte
+	for len(attributes) > 0 {
+		// flags (1), type code (1), length (1 or 2 with the extended length flag)
+		if len(attributes) < 3 {
+			return nil, nil, errors.New("path attribute is too short")
+		}
+		flags, typeCode := attributes[0], attributes[1]
+		headerLength, length := 3, int(attributes[2])
+		if flags&bgpAttributeFlagExtendedLength != 0 {
+			if len(attributes) < 4 {
+				return nil, nil, errors.New("path attribute is too short")
+			}
+			headerLength, length = 4, int(binary.BigEndian.Uint16(attributes[2:]))
+		}
+		if len(attributes) < headerLength+length {
+			return nil, nil, errors.Errorf("length of %d bytes of path attribute %d exceeds the path attributes", length, typeCode)
+		}
+		value := attributes[headerLength : headerLength+length]
+		attributes = attributes[headerLength+length:]
+
+		// AFI (2), SAFI (1), and for MP_REACH_NLRI next hop length (1), next hop and reserved (1)
+		nlriOffset := -1
+		switch typeCode {
+		case bgpAttributeMPReachNLRI:
+			if len(value) >= 4 {
+				nlriOffset = 4 + int(value[3]) + 1
+			}
+		case bgpAttributeMPUnreachNLRI:
+			nlriOffset = 3
+		}
+		if nlriOffset < 0 || len(value) < nlriOffset ||
+			!isUnicastOrMulticast(mrt.AFI(binary.BigEndian.Uint16(value)), mrt.SAFI(value[2])) {
+			stripped = appendAttribute(stripped, flags, typeCode, value)
+			continue
+		}
+
+		nlri, ids, err := stripNLRIPathIDs(value[nlriOffset:], pathIDs)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid NLRI of path attribute %d", typeCode)
+		}
+		pathIDs = ids
+		stripped = appendAttribute(stripped, flags, typeCode, append(value[:nlriOffset:nlriOffset], nlri...))
+	}
+	return stripped, pathIDs, nil
+}
+
+// stripNLRIPathIDs removes the path IDs of the prefixes and appends them to pathIDs.
+func stripNLRIPathIDs(nlri []byte, pathIDs []uint32) ([]byte, []uint32, error) {
+	var stripped []byte
+	for len(nlri) > 0 {
+		// path ID (4), prefix length (1), prefix
+		if len(nlri) < 5 {
+			return nil, nil, errors.New("prefix is too short")
+		}
+		end := 5 + (int(nlri[4])+7)/8
+		if len(nlri) < end {
+			return nil, nil, errors.New("prefix is too short")
+		}
+		pathIDs = append(pathIDs, binary.BigEndian.Uint32(nlri))
+		stripped = append(stripped, nlri[4:end]...)
+		nlri = nlri[end:]
+	}
+	return stripped, pathIDs, nil
+}
+
+// splitLengthField splits data after the field with a 2-byte length.
+func splitLengthField(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("length is missing")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return nil, nil, errors.Errorf("length of %d bytes exceeds the BGP message", length)
+	}
+	return data[2 : 2+length], data[2+length:], nil
+}
+
+// appendAttribute appends the encoded path attribute. The length is encoded with 2 bytes if the extended length flag
+// is set.
+func appendAttribute(data []byte, flags, typeCode byte, value []byte) []byte {
+	data = append(data, flags, typeCode)
+	if flags&bgpAttributeFlagExtendedLength != 0 {
+		data = appendUint16(data, uint16(len(value)))
+	} else {
+		data = append(data, byte(len(value)))
+	}
+	return append(data, value...)
+}
+
+func appendUint16(data []byte, v uint16) []byte {
+	return append(data, byte(v>>8), byte(v))
+}
+
+// isUnicastOrMulticast returns true for IPv4 and IPv6 unicast and multicast routes.
+func isUnicastOrMulticast(afi mrt.AFI, safi mrt.SAFI) bool {
+	return (afi == mrt.AFIIPv4 || afi == mrt.AFIIPv6) && (safi == mrt.SAFIUnicast || safi == mrt.SAFIMulticast)
+}
+
+// newBGP4MPAddPathMessage assigns the path IDs returned by stripAddPathIDs to the decoded prefixes of the message.
+func newBGP4MPAddPathMessage(message *mrt.BGP4MPMessage, pathIDs []uint32) (*bgp4mpAddPathMessage, error) {
+	addPathMessage := &bgp4mpAddPathMessage{
+		BGP4MPMessage: message,
+		pathIDs:       make(map[*net.IPNet]uint32),
+	}
+	if message.BGPMessage == nil {
+		return addPathMessage, nil
+	}
+	update, ok := (*message.BGPMessage).(*mrt.BGPUpdateMessage)
+	if !ok {
+		return addPathMessage, nil
+	}
+
+	prefixes := append([]*net.IPNet(nil), update.WithdrawnRoutes...)
+	for _, attribute := range update.PathAttributes {
+		switch value := attribute.Value.(type) {
+		case *mrt.BGPPathAttributeMPReachNLRI:
+			if isUnicastOrMulticast(value.AFI, value.SAFI) {
+				prefixes = append(prefixes, value.NLRI...)
+			}
+		case *mrt.BGPPathAttributeMPUnreachNLRI:
+			if isUnicastOrMulticast(value.AFI, value.SAFI) {
+				prefixes = append(prefixes, value.WithdrawnRoutes...)
+			}
+		}
+	}
+	prefixes = append(prefixes, update.NLRI...)
+
+	if len(prefixes) != len(pathIDs) {
+		return nil, errors.Errorf("decoded %d prefixes, but found %d path IDs", len(prefixes), len(pathIDs))
+	}
+	for i, prefix := range prefixes {
+		addPathMessage.pathIDs[prefix] = pathIDs[i]
+	}
+	return addPathMessage, nil
+}
@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/TheFireMike/go-mrt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// mrtRecord encodes an MRT record with the given type, subtype and body.
+func mrtRecord(recordType mrt.RecordType, subtype uint16, body []byte) []byte {
+	return mrtRecordAt(testTime, recordType, subtype, body)
+}
+
+// emptyPeerIndexTable encodes a PEER_INDEX_TABLE without view name and peers.
+func emptyPeerIndexTable() []byte {
+	return mrtRecord(mrt.TYPE_TABLE_DUMP_V2, mrt.TABLE_DUMP_V2_SUBTYPE_PEER_INDEX_TABLE, []byte{192, 0, 2, 1, 0, 0, 0, 0})
+}
+
+func TestRecordReader_Next_Truncated(t *testing.T) {
+	data := append(emptyPeerIndexTable(), emptyPeerIndexTable()...)
+	reader := newRecordReader(bytes.NewReader(data[:len(data)-3]))
+
+	rec, err := reader.Next()
+	assert.NoError(t, err)
+	assert.IsType(t, &mrt.TableDumpV2PeerIndexTable{}, rec)
+
+	_, err = reader.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRecordReader_Next_TruncatedAfterHeader(t *testing.T) {
+	data := emptyPeerIndexTable()
+	reader := newRecordReader(bytes.NewReader(data[:mrtHeaderLength]))
+
+	_, err := reader.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRecordReader_Next_InvalidRecord(t *testing.T) {
+	data := append(mrtRecord(mrt.TYPE_TABLE_DUMP_V2, 99, []byte{1, 2, 3}), emptyPeerIndexTable()...)
+	reader := newRecordReader(bytes.NewReader(data))
+
+	_, err := reader.Next()
+	assert.True(t, errors.As(err, &recordError{}))
+
+	rec, err := reader.Next()
+	assert.NoError(t, err)
+	assert.IsType(t, &mrt.TableDumpV2PeerIndexTable{}, rec)
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRecordReader_Next_TableDump(t *testing.T) {
+	attributes := concat(originAttribute(), asPathAttribute(false, asSequenceSegment(3333, 1103)), nextHopAttribute())
+	data := concat(
+		tableDumpRecord(testTime, "193.0.0.0/21", testPeer{as: 3333, ip: "192.0.2.1"}, attributes),
+		tableDumpRecord(testTime, "2001:67c:2e8::/48", testPeer{as: 3333, ip: "2001:db8::1"}, attributes),
+	)
+	reader := newRecordReader(bytes.NewReader(data))
+
+	for _, expected := range []struct {
+		prefix string
+		peerIP string
+	}{
+		{prefix: "193.0.0.0/21", peerIP: "192.0.2.1"},
+		{prefix: "2001:67c:2e8::/48", peerIP: "2001:db8::1"},
+	} {
+		rec, err := reader.Next()
+		if assert.NoError(t, err) && assert.IsType(t, &mrt.TableDump{}, rec) {
+			tableDump := rec.(*mrt.TableDump)
+			assert.Equal(t, expected.prefix, tableDump.Prefix.String())
+			assert.Equal(t, expected.peerIP, tableDump.PeerIPAddress.String())
+			assert.Equal(t, "3333", tableDump.PeerAS.String())
+			assert.Len(t, tableDump.BGPAttributes, 3)
+			asPath, _, ok := getASPath(tableDump.BGPAttributes)
+			assert.True(t, ok)
+			assert.Equal(t, "3333 1103", formatASPath(asPath))
+		}
+	}
+
+	_, err := reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStripTableDumpAttributeLength(t *testing.T) {
+	attributes := concat(originAttribute(), asPathAttribute(false, asSequenceSegment(3333, 1103)))
+	for _, route := range []struct {
+		prefix string
+		peerIP string
+	}{
+		{prefix: "193.0.0.0/21", peerIP: "192.0.2.1"},
+		{prefix: "2001:67c:2e8::/48", peerIP: "2001:db8::1"},
+	} {
+		data := tableDumpRecord(testTime, route.prefix, testPeer{as: 3333, ip: route.peerIP}, attributes)
+		subtype := binary.BigEndian.Uint16(data[6:])
+		offset := len(data) - len(attributes) - 2
+
+		checkStripped := func(stripped []byte, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, data[mrtHeaderLength:offset], stripped[mrtHeaderLength:offset])
+				assert.Equal(t, attributes, stripped[offset:])
+				assert.Equal(t, uint32(len(stripped)-mrtHeaderLength), binary.BigEndian.Uint32(stripped[8:]))
+			}
+		}
+		checkStripped(stripTableDumpAttributeLength(append([]byte{}, data...), subtype))
+		// bytes after the attributes are cut
+		checkStripped(stripTableDumpAttributeLength(append(append([]byte{}, data...), 0, 0), subtype))
+
+		// the attribute length exceeds the record
+		_, err := stripTableDumpAttributeLength(data[:len(data)-1], subtype)
+		assert.Error(t, err)
+
+		// the record ends before the attribute length
+		_, err = stripTableDumpAttributeLength(data[:offset+1], subtype)
+		assert.Error(t, err)
+	}
+}
+
+func TestRecordReader_Next_MalformedTableDump(t *testing.T) {
+	attributes := concat(originAttribute(), asPathAttribute(false, asSequenceSegment(3333, 1103)))
+	malformed := tableDumpRecord(testTime, "193.0.0.0/21", testPeer{as: 3333, ip: "192.0.2.1"}, attributes)
+	// the attribute length is increased by one
+	malformed[len(malformed)-len(attributes)-1]++
+
+	reader := newRecordReader(bytes.NewReader(append(malformed, emptyPeerIndexTable()...)))
+	_, err := reader.Next()
+	assert.True(t, errors.As(err, &recordError{}))
+
+	rec, err := reader.Next()
+	assert.NoError(t, err)
+	assert.IsType(t, &mrt.TableDumpV2PeerIndexTable{}, rec)
+}
+
+func TestConvertRIBGeneric(t *testing.T) {
+	entry := testRIBEntry{peerIndex: 1, pathID: 7, attributes: originAttribute()}
+	for _, test := range []struct {
+		subtype   uint16
+		afi       mrt.AFI
+		safi      mrt.SAFI
+		prefix    string
+		converted uint16
+	}{
+		{mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC, mrt.AFIIPv4, mrt.SAFIUnicast, "193.0.0.0/21", mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST},
+		{mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC, mrt.AFIIPv6, mrt.SAFIMulticast, "2001:67c:2e8::/48", mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_MULTICAST},
+		{mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH, mrt.AFIIPv4, mrt.SAFIMulticast, "193.0.0.0/21", mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_MULTICAST_ADDPATH},
+		{mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH, mrt.AFIIPv6, mrt.SAFIUnicast, "2001:67c:2e8::/48", mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv6_UNICAST_ADDPATH},
+	} {
+		reader := newRecordReader(bytes.NewReader(ribGenericRecord(testTime, test.subtype, test.afi, test.safi, test.prefix, entry)))
+		rec, err := reader.Next()
+		if assert.NoError(t, err) && assert.IsType(t, &mrt.TableDumpV2RIB{}, rec) {
+			rib := rec.(*mrt.TableDumpV2RIB)
+			assert.Equal(t, test.converted, rib.Subtype())
+			assert.Equal(t, test.prefix, rib.Prefix.String())
+			if assert.Len(t, rib.RIBEntries, 1) {
+				assert.Equal(t, uint16(1), rib.RIBEntries[0].PeerIndex)
+				assert.Len(t, rib.RIBEntries[0].BGPAttributes, 1)
+				if test.subtype == mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC_ADDPATH {
+					assert.Equal(t, uint32(7), rib.RIBEntries[0].PathIdentifier)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertRIBGeneric_Unsupported(t *testing.T) {
+	// MPLS-labeled VPN routes
+	data := ribGenericRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC, mrt.AFIIPv4, 128, "193.0.0.0/21")
+	converted, ok := convertRIBGeneric(data, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC)
+	assert.False(t, ok)
+	assert.Equal(t, data, converted)
+
+	// the record ends before the SAFI
+	_, ok = convertRIBGeneric(data[:mrtHeaderLength+6], mrt.TABLE_DUMP_V2_SUBTYPE_RIB_GENERIC)
+	assert.False(t, ok)
+}
+
+func TestRecordReader_Next_AddPath(t *testing.T) {
+	peer := testPeer{as: 3333, ip: "192.0.2.1"}
+	pathIDs := map[string]uint32{
+		"193.0.0.0/21":      1,
+		"193.0.8.0/23":      2,
+		"2001:67c:2e8::/48": 3,
+		"193.0.16.0/21":     4,
+	}
+	// enough prefixes for an MP_REACH_NLRI attribute with extended length
+	var reach [][]byte
+	for i := 1; i <= 30; i++ {
+		prefix := fmt.Sprintf("2001:db8:%x::/48", i)
+		reach = append(reach, encodeAddPathNLRI(uint32(100+i), prefix))
+		pathIDs[prefix] = uint32(100 + i)
+	}
+	message := bgpUpdate(
+		concat(encodeAddPathNLRI(1, "193.0.0.0/21"), encodeAddPathNLRI(2, "193.0.8.0/23")),
+		concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333, 1103)), nextHopAttribute(),
+			mpUnreachAttribute(encodeAddPathNLRI(3, "2001:67c:2e8::/48")), mpReachAttribute(reach...)),
+		encodeAddPathNLRI(4, "193.0.16.0/21"))
+
+	reader := newRecordReader(bytes.NewReader(concat(
+		bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH, peer, message),
+		bgp4mpETMessage(testTime, 1, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4_ADDPATH, peer, message),
+	)))
+	for i := 0; i < 2; i++ {
+		rec, err := reader.Next()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if extended, ok := rec.(*extendedTimestampRecord); ok {
+			rec = extended.Record
+		}
+		if assert.IsType(t, &bgp4mpAddPathMessage{}, rec) {
+			addPathMessage := rec.(*bgp4mpAddPathMessage)
+			assert.Equal(t, "3333", addPathMessage.PeerAS.String())
+			decoded := make(map[string]uint32)
+			for prefix, pathID := range addPathMessage.pathIDs {
+				decoded[prefix.String()] = pathID
+			}
+			assert.Equal(t, pathIDs, decoded)
+			update := (*addPathMessage.BGPMessage).(*mrt.BGPUpdateMessage)
+			assert.Len(t, update.PathAttributes, 5)
+		}
+	}
+}
+
+func TestRecordReader_Next_MalformedAddPath(t *testing.T) {
+	peer := testPeer{as: 3333, ip: "192.0.2.1"}
+	valid := bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_ADDPATH, peer,
+		bgpUpdate(encodeAddPathNLRI(1, "193.0.0.0/21"), nil, nil))
+	for _, message := range [][]byte{
+		// the path ID is missing
+		bgpUpdate(encodeNLRI("193.0.0.0/21"), nil, nil),
+		// the prefix is cut
+		bgpUpdate(nil, originAttribute(), encodeAddPathNLRI(1, "193.0.0.0/21")[:6]),
+		// the length of the withdrawn routes exceeds the message
+		bgpUpdate(nil, nil, nil)[:19],
+		bgpUpdate(nil, mpUnreachAttribute(encodeAddPathNLRI(1, "2001:67c:2e8::/48")[:7]), nil),
+	} {
+		reader := newRecordReader(bytes.NewReader(concat(
+			bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_ADDPATH, peer, message),
+			valid,
+		)))
+		_, err := reader.Next()
+		assert.ErrorAs(t, err, &recordError{})
+
+		rec, err := reader.Next()
+		if assert.NoError(t, err) {
+			assert.IsType(t, &bgp4mpAddPathMessage{}, rec)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package parser
+
+import (
+	"container/heap"
+	"github.com/TheFireMike/go-mrt"
+	"io"
+	"time"
+)
+
+// replayFiles processes all table dumps first and afterwards applies the BGP updates of all update files in timestamp
+// order. Updates older than the oldest table dump or newer than until are skipped. The table dumps are processed by
+// the given number of workers, while all update files stay open from reading their first record until the end of the
+// merge, so they are only read once. After the run was aborted, the remaining files and updates are skipped.
+func replayFiles(files []*mrtFile, until time.Time, workers int) {
+	var dumps, updates []*mrtFile
+	var first []mrt.Record
+	var snapshot time.Time
+	for _, f := range files {
+		if f.abort.aborted() {
+			break
+		}
+		rec, err := f.peek()
+		if err != nil {
+			f.fail(err)
+			continue
+		}
+		if rec == nil {
+			f.report()
+			continue
+		}
+
+		switch rec.Type() {
+		case mrt.TYPE_TABLE_DUMP, mrt.TYPE_TABLE_DUMP_V2:
+			// the table dumps are read again from the start by the workers
+			f.close()
+			f.resetStatistics()
+			dumps = append(dumps, f)
+			if snapshot.IsZero() || rec.Timestamp().Before(snapshot) {
+				snapshot = rec.Timestamp()
+			}
+		case mrt.TYPE_BGP4MP, mrt.TYPE_BGP4MP_ET:
+			updates = append(updates, f)
+			first = append(first, rec)
+		default:
+			f.logger.Trace().Msgf("skipping file with unknown mrt entry type: '%v'", rec.Type())
+			f.close()
+			f.report()
+		}
+	}
+
+	processConcurrently(dumps, workers)
+
+	// the updates of all files share the batchers, so that the announcements reach the routing table in timestamp order
+	var shared *batchers
+	if len(updates) > 0 {
+		shared = newBatchers(updates[0].channels)
+	}
+
+	var queue recordQueue
+	for i, f := range updates {
+		f.batchers = shared
+		queue = append(queue, &queuedRecord{
+			file:   f,
+			order:  i,
+			record: first[i],
+		})
+	}
+	heap.Init(&queue)
+
+	for queue.Len() > 0 {
+		item := heap.Pop(&queue).(*queuedRecord)
+		if item.file.abort.aborted() {
+			break
+		}
+		if !until.IsZero() && item.record.Timestamp().After(until) {
+			// the records of a file are ordered by time, so the rest of the file can be skipped as well
+			continue
+		}
+		if !item.record.Timestamp().Before(snapshot) {
+			item.file.processRecord(item.record)
+		}
+		if next := nextQueuedRecord(item.file, item.order); next != nil {
+			heap.Push(&queue, next)
+		}
+	}
+
+	if shared != nil {
+		shared.flush()
+	}
+	for _, f := range updates {
+		f.close()
+		f.report()
+	}
+}
+
+// replayStream applies the records of a single stream in their order, as a stream can only be read once. Records newer
+// than until are skipped.
+func replayStream(f *mrtFile, until time.Time) {
+	defer f.report()
+
+	err := f.open()
+	if err != nil {
+		f.sendError(err)
+		return
+	}
+	defer f.close()
+
+	for {
+		rec, err := f.next()
+		if err == io.EOF {
+			break
+		}
+		if until.IsZero() || !rec.Timestamp().After(until) {
+			f.processRecord(rec)
+		}
+	}
+	f.batchers.flush()
+}
+
+// peek opens the file and returns its first MRT record, or nil if the file does not contain any records. Unless no
+// record is returned, the file stays open, so that the following records can be read without opening it again.
+func (f *mrtFile) peek() (mrt.Record, error) {
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := f.next()
+	if err == io.EOF {
+		f.close()
+		return nil, nil
+	}
+	return rec, err
+}
+
+// resetStatistics resets the statistics of reading the file, so that they only cover the actual processing of the
+// file.
+func (f *mrtFile) resetStatistics() {
+	f.statistics.Bytes, f.statistics.Records, f.statistics.Duration = 0, 0, 0
+	f.statistics.PeerIndexRecords, f.statistics.RIBRecords = 0, 0
+	f.statistics.ObservationStart, f.statistics.ObservationEnd = nil, nil
+}
+
+type queuedRecord struct {
+	file   *mrtFile
+	order  int
+	record mrt.Record
+}
+
+// nextQueuedRecord reads the next record of the file. It returns nil if the file has no more records.
+func nextQueuedRecord(f *mrtFile, order int) *queuedRecord {
+	rec, err := f.next()
+	if err != nil {
+		return nil
+	}
+	return &queuedRecord{
+		file:   f,
+		order:  order,
+		record: rec,
+	}
+}
+
+// recordQueue is a priority queue which returns the records of multiple files ordered by timestamp. Records with the
+// same timestamp are returned in the order of their files.
+type recordQueue []*queuedRecord
+
+func (q recordQueue) Len() int {
+	return len(q)
+}
+
+func (q recordQueue) Less(i, j int) bool {
+	if q[i].record.Timestamp().Equal(q[j].record.Timestamp()) {
+		return q[i].order < q[j].order
+	}
+	return q[i].record.Timestamp().Before(q[j].record.Timestamp())
+}
+
+func (q recordQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *recordQueue) Push(x interface{}) {
+	*q = append(*q, x.(*queuedRecord))
+}
+
+func (q *recordQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// countingFS counts how often every file is opened.
+type countingFS struct {
+	fs.FS
+	mutex sync.Mutex
+	opens map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mutex.Lock()
+	c.opens[name]++
+	c.mutex.Unlock()
+	return c.FS.Open(name)
+}
+
+func TestReplayFiles(t *testing.T) {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}, {as: 174, ip: "192.0.2.2"}}
+	announce := func(timestamp uint32, origin uint32) []byte {
+		return bgp4mpMessage(timestamp, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peers[1], bgpUpdate(nil,
+			concat(originAttribute(), asPathAttribute(true, asSequenceSegment(174, origin)), nextHopAttribute()),
+			encodeNLRI("193.0.0.0/21")))
+	}
+	withdraw := func(timestamp uint32) []byte {
+		return bgp4mpMessage(timestamp, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peers[1], bgpUpdate(
+			encodeNLRI("193.0.0.0/21"), nil, nil))
+	}
+
+	fsys := &countingFS{
+		FS: fstest.MapFS{
+			"rrc00/bview.20200913.1200": {Data: concat(
+				peerIndexTable(testTime, peers...),
+				ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+					testRIBEntry{peerIndex: 0, attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333)), nextHopAttribute())}),
+			)},
+			// the announcement before the table dump is skipped, otherwise the event would start before the dump
+			"rrc00/updates.20200913.1200": {Data: concat(announce(testTime-60, 13335), withdraw(testTime+120))},
+			// the updates of both files are merged, so the withdrawal of the first file ends the event of the second
+			// file, while the last announcement is after the end of the replay
+			"rrc00/updates.20200913.1205": {Data: concat(announce(testTime+90, 13335), announce(testTime+180, 13335))},
+		},
+		opens: make(map[string]int),
+	}
+
+	directory := detectMOASInFS(t, fsys, Config{Replay: true, ReplayUntil: time.Unix(testTime+150, 0)})
+
+	var events []routes.MOASEvent
+	readOutput(t, directory, "moasEvents.json", &events)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "193.0.0.0/21", events[0].Prefix)
+		assert.True(t, time.Unix(testTime+90, 0).Equal(events[0].Start))
+		if assert.NotNil(t, events[0].End) {
+			assert.True(t, time.Unix(testTime+120, 0).Equal(*events[0].End))
+		}
+	}
+
+	var moas []routes.MOASPrefix
+	readOutput(t, directory, "moasIPv4.json", &moas)
+	assert.Empty(t, moas)
+
+	// every file is opened once to detect archives, the table dump once more to find the oldest table dump
+	assert.Equal(t, 3, fsys.opens["rrc00/bview.20200913.1200"])
+	assert.Equal(t, 2, fsys.opens["rrc00/updates.20200913.1200"])
+	assert.Equal(t, 2, fsys.opens["rrc00/updates.20200913.1205"])
+
+	var statistics routes.Statistics
+	readOutput(t, directory, "statistics.json", &statistics)
+	for _, file := range statistics.Files {
+		assert.Equal(t, 2, file.Records, file.Name)
+	}
+}
+
+func TestReplayFiles_FlatDirectory(t *testing.T) {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}, {as: 174, ip: "192.0.2.2"}}
+	dump := func(collectorBGPID string) []byte {
+		return concat(
+			collectorPeerIndexTable(testTime, collectorBGPID, peers...),
+			ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+				testRIBEntry{peerIndex: 0, attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333)), nextHopAttribute())},
+				testRIBEntry{peerIndex: 1, attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(174, 13335)), nextHopAttribute())}),
+		)
+	}
+	updates := bgp4mpMessage(testTime+60, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peers[1], bgpUpdate(encodeNLRI("193.0.0.0/21"), nil, nil))
+
+	// without collector directories, the update file gets the collector of the table dump in the same directory, so
+	// their peers are the same
+	directory := detectMOASInFS(t, fstest.MapFS{
+		"bview.20200913.1200":   {Data: dump("192.0.2.254")},
+		"updates.20200913.1200": {Data: updates},
+	}, Config{Replay: true})
+
+	var moas []routes.MOASPrefix
+	readOutput(t, directory, "moasIPv4.json", &moas)
+	assert.Empty(t, moas)
+
+	var statistics routes.Statistics
+	readOutput(t, directory, "statistics.json", &statistics)
+	if assert.Len(t, statistics.Peers, 2) {
+		assert.Equal(t, "192.0.2.254", statistics.Peers[0].Collector)
+		assert.Equal(t, "192.0.2.254", statistics.Peers[1].Collector)
+	}
+	for _, file := range statistics.Files {
+		assert.Equal(t, "192.0.2.254", file.Collector, file.Name)
+	}
+
+	// the table dumps of two collectors in the same directory keep their peers apart, while the collector of the
+	// update file is unknown
+	directory = detectMOASInFS(t, fstest.MapFS{
+		"bview.20200913.1200.gz.1": {Data: dump("192.0.2.253")},
+		"bview.20200913.1200.gz.2": {Data: dump("192.0.2.254")},
+		"updates.20200913.1200":    {Data: updates},
+	}, Config{Replay: true})
+
+	statistics = routes.Statistics{}
+	readOutput(t, directory, "statistics.json", &statistics)
+	assert.Len(t, statistics.Peers, 5)
+	assert.Len(t, statistics.Collectors, 3)
+	for _, file := range statistics.Files {
+		switch file.Name {
+		case "bview.20200913.1200.gz.1":
+			assert.Equal(t, "192.0.2.253", file.Collector)
+		case "bview.20200913.1200.gz.2":
+			assert.Equal(t, "192.0.2.254", file.Collector)
+		default:
+			assert.Empty(t, file.Collector)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotSelection selects the table dumps which are processed. Update files are not affected.
+type SnapshotSelection struct {
+	// Target selects the table dump closest to this time per collector.
+	Target time.Time
+	// From and To select all table dumps in this time range (inclusive) if Target is not set.
+	From time.Time
+	To   time.Time
+	// Tolerance is the maximum distance between a table dump and Target. Dumps which are further away are still
+	// selected, but flagged in the statistics.
+	Tolerance time.Duration
+}
+
+func (s SnapshotSelection) enabled() bool {
+	return !s.Target.IsZero() || !s.From.IsZero() || !s.To.IsZero()
+}
+
+const (
+	fileTypeUnknown = iota
+	fileTypeDump
+	fileTypeUpdates
+)
+
+// mrtFileName matches the file names of RIS (bview.20220205.0000.gz, updates.20220205.0000.gz) and RouteViews
+// (rib.20220205.1400.bz2, updates.20220205.1400.bz2).
+var mrtFileName = regexp.MustCompile(`^(bview|rib|updates)\.(\d{8}\.\d{4})(\.|$)`)
+
+// parseFileName returns the type and the time of a RIS or RouteViews MRT file from its file name. The type is
+// fileTypeUnknown if the name does not follow the naming scheme.
+func parseFileName(name string) (int, time.Time) {
+	match := mrtFileName.FindStringSubmatch(path.Base(filepath.ToSlash(name)))
+	if match == nil {
+		return fileTypeUnknown, time.Time{}
+	}
+
+	timestamp, err := time.Parse("20060102.1504", match[2])
+	if err != nil {
+		return fileTypeUnknown, time.Time{}
+	}
+
+	if match[1] == "updates" {
+		return fileTypeUpdates, timestamp
+	}
+	return fileTypeDump, timestamp
+}
+
+// getFileTime determines the type, time and collector of the file. The type and time are taken from the file name and
+// otherwise from the first MRT record, the collector from the path and otherwise from the peer index table. The
+// collector is only determined for table dumps. If the first record can not be read, the type is fileTypeUnknown and
+// the error is returned.
+func (f *mrtFile) getFileTime() (int, time.Time, string, error) {
+	fileType, timestamp := parseFileName(f.name)
+	if fileType == fileTypeUpdates || (fileType == fileTypeDump && f.collector != "") {
+		return fileType, timestamp, f.collector, nil
+	}
+
+	// the first record is read by a copy of the file, so that the statistics only cover the actual processing of the
+	// file and errors are only reported when the file is processed
+	first := newMRTFile(f.source, routes.Channels{}, Config{ContinueOnError: true}, nil)
+	rec, err := first.peek()
+	if rec != nil {
+		first.close()
+	}
+	switch {
+	case err != nil:
+		return fileTypeUnknown, time.Time{}, "", err
+	case first.statistics.Error != "":
+		return fileTypeUnknown, time.Time{}, "", errors.New(first.statistics.Error)
+	case first.statistics.Truncated:
+		return fileTypeUnknown, time.Time{}, "", errors.New("file is truncated or corrupt")
+	case rec == nil:
+		return fileTypeUnknown, time.Time{}, "", nil
+	}
+
+	if fileType == fileTypeUnknown {
+		timestamp = rec.Timestamp()
+	}
+	switch rec.Type() {
+	case mrt.TYPE_TABLE_DUMP, mrt.TYPE_TABLE_DUMP_V2:
+		collector := f.collector
+		if peers, ok := rec.(*mrt.TableDumpV2PeerIndexTable); ok && collector == "" {
+			collector = getCollectorFromPeerIndexTable(peers)
+		}
+		return fileTypeDump, timestamp, collector, nil
+	case mrt.TYPE_BGP4MP, mrt.TYPE_BGP4MP_ET:
+		return fileTypeUpdates, timestamp, f.collector, nil
+	default:
+		return fileTypeUnknown, time.Time{}, "", nil
+	}
+}
+
+// selectSnapshots returns the files without the table dumps which are not selected. Dumps of which the collector is
+// unknown are handled like the dumps of one collector.
+func selectSnapshots(files []*mrtFile, selection SnapshotSelection) []*mrtFile {
+	dumpTimes := make(map[*mrtFile]time.Time)
+	closest := make(map[string]*mrtFile)
+	for _, f := range files {
+		fileType, timestamp, collector, _ := f.getFileTime()
+		if fileType != fileTypeDump {
+			continue
+		}
+		dumpTimes[f] = timestamp
+
+		if !selection.Target.IsZero() {
+			if c, ok := closest[collector]; !ok || absDuration(timestamp.Sub(selection.Target)) < absDuration(dumpTimes[c].Sub(selection.Target)) {
+				closest[collector] = f
+			}
+		}
+	}
+
+	selected := make(map[*mrtFile]struct{})
+	for _, f := range closest {
+		selected[f] = struct{}{}
+	}
+
+	var result []*mrtFile
+	for _, f := range files {
+		timestamp, isDump := dumpTimes[f]
+		if !isDump {
+			result = append(result, f)
+			continue
+		}
+
+		if selection.Target.IsZero() {
+			if (!selection.From.IsZero() && timestamp.Before(selection.From)) || (!selection.To.IsZero() && timestamp.After(selection.To)) {
+				f.logger.Trace().Time("dump_time", timestamp).Msg("skipping table dump outside of the snapshot range")
+				continue
+			}
+		} else {
+			if _, ok := selected[f]; !ok {
+				f.logger.Trace().Time("dump_time", timestamp).Msg("skipping table dump which is not closest to the snapshot time")
+				continue
+			}
+
+			distance := int64(timestamp.Sub(selection.Target).Seconds())
+			f.statistics.TargetDistance = &distance
+			if absDuration(timestamp.Sub(selection.Target)) > selection.Tolerance {
+				f.statistics.TooFarFromTarget = true
+				f.logger.Warn().Time("dump_time", timestamp).Msg("closest table dump is too far from the snapshot time")
+			}
+		}
+
+		f.statistics.DumpTime = &timestamp
+		result = append(result, f)
+	}
+	return result
+}
+
+// checkSkew returns an error if the table dumps are more than maxSkew apart, or if an update file starts more than
+// maxSkew before the first or after the last table dump. The times of the files are taken from their file names or
+// their first MRT records.
+func checkSkew(files []*mrtFile, maxSkew time.Duration) error {
+	var first, last time.Time
+	updates := make(map[string]time.Time)
+	for _, f := range files {
+		fileType, timestamp, _, _ := f.getFileTime()
+		switch fileType {
+		case fileTypeDump:
+			if first.IsZero() || timestamp.Before(first) {
+				first = timestamp
+			}
+			if last.IsZero() || timestamp.After(last) {
+				last = timestamp
+			}
+		case fileTypeUpdates:
+			updates[f.name] = timestamp
+		}
+	}
+
+	if skew := last.Sub(first); skew > maxSkew {
+		return errors.Errorf("table dumps from %s to %s exceed the maximum skew of %s", first.Format(time.RFC3339), last.Format(time.RFC3339), maxSkew)
+	}
+	if first.IsZero() {
+		return nil
+	}
+
+	var outside []string
+	for name, timestamp := range updates {
+		if timestamp.Before(first.Add(-maxSkew)) || timestamp.After(last.Add(maxSkew)) {
+			outside = append(outside, name)
+		}
+	}
+	if len(outside) > 0 {
+		sort.Strings(outside)
+		return errors.Errorf("update files %s are not within the maximum skew of %s of the table dumps from %s to %s", strings.Join(outside, ", "), maxSkew, first.Format(time.RFC3339), last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Snapshot is a group of input files of the same time interval, see GroupSnapshots.
+type Snapshot struct {
+	// Time is the start of the interval.
+	Time    time.Time
+	sources []source
+}
+
+// Files returns the names of the files of the snapshot.
+func (s Snapshot) Files() []string {
+	var names []string
+	for _, src := range s.sources {
+		names = append(names, src.name)
+	}
+	return names
+}
+
+// GroupSnapshots groups all files in the directory by their time into snapshots of the given interval, which should
+// divide a day (e.g. 8h for RIS or 2h for RouteViews). Files of which the time can not be determined, e.g. corrupt
+// files, are returned as skipped files, ordered by name. The snapshots are ordered by time and filtered by the time
+// range of the snapshot selection in the config.
+func GroupSnapshots(directory string, config Config, interval time.Duration) ([]Snapshot, []routes.SkippedFile, error) {
+	fsys, root, prefix, err := openDirectory(directory)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading input directory failed")
+	}
+	sources, brokenSources, err := findSources(fsys, root, prefix, config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading input directory failed")
+	}
+
+	var skipped []routes.SkippedFile
+	for _, broken := range brokenSources {
+		if !config.ContinueOnError {
+			return nil, nil, errors.Wrapf(broken.err, "processing file '%s' failed", broken.name)
+		}
+		log.Error().Err(broken.err).Str("file", broken.name).Msg("processing file failed")
+		skipped = append(skipped, routes.SkippedFile{
+			Name:   broken.name,
+			Reason: broken.err.Error(),
+		})
+	}
+
+	snapshots := make(map[time.Time]*Snapshot)
+	for _, src := range sources {
+		f := newMRTFile(src, routes.Channels{}, config, nil)
+		fileType, timestamp, _, err := f.getFileTime()
+		if fileType == fileTypeUnknown {
+			reason := "time of the file is unknown"
+			if err != nil {
+				reason = err.Error()
+			}
+			f.logger.Warn().Str("reason", reason).Msg("skipping file of which the time is unknown")
+			skipped = append(skipped, routes.SkippedFile{
+				Name:   src.name,
+				Reason: reason,
+			})
+			continue
+		}
+
+		snapshotTime := timestamp.UTC().Truncate(interval)
+		if (!config.Snapshots.From.IsZero() && snapshotTime.Before(config.Snapshots.From)) ||
+			(!config.Snapshots.To.IsZero() && snapshotTime.After(config.Snapshots.To)) {
+			continue
+		}
+
+		snapshot, ok := snapshots[snapshotTime]
+		if !ok {
+			snapshot = &Snapshot{
+				Time: snapshotTime,
+			}
+			snapshots[snapshotTime] = snapshot
+		}
+		snapshot.sources = append(snapshot.sources, src)
+	}
+
+	var result []Snapshot
+	for _, snapshot := range snapshots {
+		result = append(result, *snapshot)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Name < skipped[j].Name
+	})
+	return result, skipped, nil
+}
+
+// ProcessSnapshot processes the files of the snapshot like ProcessFiles. For every collector, only the table dump
+// closest to the start of the snapshot is processed.
+func ProcessSnapshot(snapshot Snapshot, channels routes.Channels, config Config) {
+	defer channels.Close()
+
+	config = withAbortSignal(config)
+	processSources(snapshot.sources, channels, config, SnapshotSelection{
+		Target:    snapshot.Time,
+		Tolerance: config.Snapshots.Tolerance,
+	})
+}
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"github.com/TheFireMike/go-mrt"
+	"github.com/TheFireMike/moasDetector/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseFileName_RIS(t *testing.T) {
+	fileType, timestamp := parseFileName("rrc00/2022.02/bview.20220205.0800.gz")
+	assert.Equal(t, fileTypeDump, fileType)
+	assert.Equal(t, time.Date(2022, 2, 5, 8, 0, 0, 0, time.UTC), timestamp)
+
+	fileType, timestamp = parseFileName("rrc00/2022.02/updates.20220205.0805.gz")
+	assert.Equal(t, fileTypeUpdates, fileType)
+	assert.Equal(t, time.Date(2022, 2, 5, 8, 5, 0, 0, time.UTC), timestamp)
+}
+
+func TestParseFileName_RouteViews(t *testing.T) {
+	fileType, timestamp := parseFileName("route-views2/bgpdata/2022.02/RIBS/rib.20220205.1400.bz2")
+	assert.Equal(t, fileTypeDump, fileType)
+	assert.Equal(t, time.Date(2022, 2, 5, 14, 0, 0, 0, time.UTC), timestamp)
+}
+
+func TestParseFileName_Unknown(t *testing.T) {
+	fileType, _ := parseFileName("rrc00/latest-bview.gz")
+	assert.Equal(t, fileTypeUnknown, fileType)
+
+	fileType, _ = parseFileName("rrc00/bview.20221305.0000.gz")
+	assert.Equal(t, fileTypeUnknown, fileType)
+
+	fileType, _ = parseFileName("rrc00/mybview.20220205.0000.gz")
+	assert.Equal(t, fileTypeUnknown, fileType)
+}
+
+func TestCheckSkew(t *testing.T) {
+	files := func(names ...string) []*mrtFile {
+		var result []*mrtFile
+		for _, name := range names {
+			result = append(result, newMRTFile(source{name: name}, routes.Channels{}, Config{}, nil))
+		}
+		return result
+	}
+
+	assert.NoError(t, checkSkew(files(
+		"rrc00/bview.20200913.0800.gz",
+		"rrc01/bview.20200913.0830.gz",
+		"rrc00/updates.20200913.0745.gz",
+		"rrc01/updates.20200913.0855.gz",
+	), time.Hour))
+
+	err := checkSkew(files(
+		"rrc00/bview.20200913.0800.gz",
+		"rrc01/bview.20200913.1000.gz",
+	), time.Hour)
+	assert.EqualError(t, err, "table dumps from 2020-09-13T08:00:00Z to 2020-09-13T10:00:00Z exceed the maximum skew of 1h0m0s")
+
+	// update files of another day do not belong to the snapshot of the table dumps
+	err = checkSkew(files(
+		"rrc00/bview.20200913.0800.gz",
+		"rrc00/updates.20200913.0805.gz",
+		"rrc01/updates.20200914.0805.gz",
+		"rrc00/updates.20200912.0805.gz",
+	), time.Hour)
+	assert.EqualError(t, err, "update files rrc00/updates.20200912.0805.gz, rrc01/updates.20200914.0805.gz are not within the maximum skew of 1h0m0s of the table dumps from 2020-09-13T08:00:00Z to 2020-09-13T08:00:00Z")
+
+	// without table dumps, the update files are not checked
+	assert.NoError(t, checkSkew(files("rrc00/updates.20200912.0805.gz", "rrc00/updates.20200914.0805.gz"), time.Hour))
+}
+
+func TestSelectSnapshots_Statistics(t *testing.T) {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}}
+	dump := concat(
+		peerIndexTable(testTime, peers...),
+		ribRecord(testTime, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+			testRIBEntry{peerIndex: 0, attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333)), nextHopAttribute())}),
+	)
+
+	// the time and collector of the table dump are taken from its first record, which does not count as processed
+	directory := detectMOASInFS(t, fstest.MapFS{"dump.mrt": {Data: dump}}, Config{
+		Snapshots: SnapshotSelection{Target: time.Unix(testTime, 0)},
+	})
+
+	var statistics routes.Statistics
+	readOutput(t, directory, "statistics.json", &statistics)
+	if assert.Len(t, statistics.Files, 1) {
+		assert.Equal(t, 2, statistics.Files[0].Records)
+		assert.Equal(t, int64(len(dump)), statistics.Files[0].Bytes)
+		if assert.NotNil(t, statistics.Files[0].DumpTime) {
+			assert.True(t, time.Unix(testTime, 0).Equal(*statistics.Files[0].DumpTime))
+		}
+	}
+}
+
+func TestGroupSnapshots(t *testing.T) {
+	peers := []testPeer{{as: 3333, ip: "192.0.2.1"}}
+	dump := func(timestamp uint32) []byte {
+		return concat(
+			peerIndexTable(timestamp, peers...),
+			ribRecord(timestamp, mrt.TABLE_DUMP_V2_SUBTYPE_RIB_IPv4_UNICAST, 0, "193.0.0.0/21",
+				testRIBEntry{peerIndex: 0, attributes: concat(originAttribute(), asPathAttribute(true, asSequenceSegment(3333)), nextHopAttribute())}),
+		)
+	}
+	updates := bgp4mpMessage(testTime, mrt.BGP4MP_SUBTYPE_BGP4MP_MESSAGE_AS4, peers[0], bgpUpdate(encodeNLRI("193.0.0.0/21"), nil, nil))
+
+	// testTime is 2020-09-13 12:26:40 UTC
+	directory := t.TempDir()
+	files := map[string][]byte{
+		"rrc00/bview.20200913.0800":   dump(testTime),
+		"rrc00/bview.20200913.1600":   dump(testTime),
+		"rrc00/updates.20200913.0805": updates,
+		// the time of the file is taken from its first record
+		"rrc00/dump.mrt":            dump(testTime),
+		"rrc01/bview.20200913.1000": dump(testTime),
+		"rrc01/bview.20200913.1200": dump(testTime),
+		"rrc01/corrupt.gz":          {0x1f, 0x8b, 0x08, 0x00, 0x01},
+		"rrc01/empty.mrt":           {},
+		// OSPF records do not have a file type
+		"rrc01/ospf.mrt": mrtRecordAt(testTime, mrt.TYPE_OSPFv2, 0, concat(encodeIP("192.0.2.1"), encodeIP("192.0.2.2"))),
+	}
+	for name, data := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(directory, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(directory, name), data, 0644))
+	}
+	path := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(directory, name))
+		}
+		return paths
+	}
+
+	snapshots, skipped, err := GroupSnapshots(directory, Config{}, 8*time.Hour)
+	require.NoError(t, err)
+	if assert.Len(t, snapshots, 2) {
+		assert.Equal(t, time.Date(2020, 9, 13, 8, 0, 0, 0, time.UTC), snapshots[0].Time)
+		assert.Equal(t, path("rrc00/bview.20200913.0800", "rrc00/dump.mrt", "rrc00/updates.20200913.0805",
+			"rrc01/bview.20200913.1000", "rrc01/bview.20200913.1200"), snapshots[0].Files())
+		assert.Equal(t, time.Date(2020, 9, 13, 16, 0, 0, 0, time.UTC), snapshots[1].Time)
+		assert.Equal(t, path("rrc00/bview.20200913.1600"), snapshots[1].Files())
+	}
+	if assert.Len(t, skipped, 3) {
+		assert.Equal(t, path("rrc01/corrupt.gz")[0], skipped[0].Name)
+		assert.NotEmpty(t, skipped[0].Reason)
+		assert.Equal(t, routes.SkippedFile{Name: path("rrc01/empty.mrt")[0], Reason: "unknown file type"}, skipped[1])
+		assert.Equal(t, routes.SkippedFile{Name: path("rrc01/ospf.mrt")[0], Reason: "time of the file is unknown"}, skipped[2])
+	}
+
+	// only the table dump closest to the start of the snapshot is processed per collector
+	output := detectMOAS(t, func(channels routes.Channels) {
+		ProcessSnapshot(snapshots[0], channels, Config{})
+	}, Config{})
+	var statistics routes.Statistics
+	readOutput(t, output, "statistics.json", &statistics)
+	dumpTimes := make(map[string]time.Time)
+	for _, file := range statistics.Files {
+		if file.DumpTime != nil {
+			dumpTimes[file.Name] = file.DumpTime.UTC()
+		}
+	}
+	assert.Equal(t, map[string]time.Time{
+		path("rrc00/bview.20200913.0800")[0]: time.Date(2020, 9, 13, 8, 0, 0, 0, time.UTC),
+		path("rrc01/bview.20200913.1000")[0]: time.Date(2020, 9, 13, 10, 0, 0, 0, time.UTC),
+	}, dumpTimes)
+	assert.Len(t, statistics.Files, 3)
+}
@@ -0,0 +1,318 @@
+package parser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+const tarBlockLength = 512
+
+var magicZip = []byte{'P', 'K', 0x03, 0x04}
+
+type archiveType int
+
+const (
+	archiveNone archiveType = iota
+	archiveTar
+	archiveTarGzip
+	archiveZip
+)
+
+// source is an input file which can be opened (multiple times) to read its content.
+type source struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// readCloser combines a reader with a function which releases the resources of the reader.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// getSources returns the file itself, or the files in it if the file is a tar, gzipped tar or zip archive. Archives
+// inside archives are not descended into.
+func getSources(name string, open func() (io.ReadCloser, error)) ([]source, error) {
+	archive, err := detectArchive(open)
+	if err != nil {
+		return nil, err
+	}
+
+	switch archive {
+	case archiveTar, archiveTarGzip:
+		return getTarSources(name, open, archive == archiveTarGzip)
+	case archiveZip:
+		return getZipSources(name, open)
+	default:
+		return []source{{name: name, open: open}}, nil
+	}
+}
+
+// detectArchive detects the archive type of the content by its first bytes.
+func detectArchive(open func() (io.ReadCloser, error)) (archiveType, error) {
+	r, err := open()
+	if err != nil {
+		return archiveNone, err
+	}
+	defer r.Close()
+
+	header, err := readTarBlock(r)
+	if err != nil {
+		return archiveNone, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, magicZip):
+		return archiveZip, nil
+	case isTarHeader(header):
+		return archiveTar, nil
+	case bytes.HasPrefix(header, magicGzip):
+		content, err := gzip.NewReader(io.MultiReader(bytes.NewReader(header), r))
+		if err != nil {
+			// not an archive, the error is reported when reading the MRT records
+			return archiveNone, nil
+		}
+		defer content.Close()
+
+		header, err = readTarBlock(content)
+		if err == nil && isTarHeader(header) {
+			return archiveTarGzip, nil
+		}
+	}
+	return archiveNone, nil
+}
+
+// readTarBlock reads the first block of a tar archive. Shorter content is returned as it is.
+func readTarBlock(r io.Reader) ([]byte, error) {
+	block := make([]byte, tarBlockLength)
+	n, err := io.ReadFull(r, block)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return block[:n], nil
+}
+
+// isTarHeader checks whether the data starts with a POSIX or GNU tar header.
+func isTarHeader(data []byte) bool {
+	// the magic field is at offset 257
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+// tarEntry is the location of the content of a regular file in a tar archive.
+type tarEntry struct {
+	name   string
+	offset int64
+	size   int64
+}
+
+// getTarSources lists the files of a tar archive with the location of their content, so they can be opened without
+// reading the previous entries again. A gzipped archive is decompressed once into a temporary file, from which its
+// files are read.
+func getTarSources(name string, open func() (io.ReadCloser, error), gzipped bool) ([]source, error) {
+	if gzipped {
+		return getTarGzipSources(name, open)
+	}
+
+	file, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := listTar(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []source
+	for _, entry := range entries {
+		entry := entry
+		sources = append(sources, source{
+			name: path.Join(name, entry.name),
+			open: func() (io.ReadCloser, error) {
+				return openTarEntry(open, entry)
+			},
+		})
+	}
+	return sources, nil
+}
+
+// getTarGzipSources decompresses the archive into a temporary file and lists its files. The temporary file is removed
+// right away, but stays open until the program exits, so the files can be read from it.
+func getTarGzipSources(name string, open func() (io.ReadCloser, error)) ([]source, error) {
+	file, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	tmp, err := os.CreateTemp("", "moasDetector-*.tar")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating temporary file failed")
+	}
+	// the removal fails on systems which do not allow to remove open files, so the file is left behind there
+	_ = os.Remove(tmp.Name())
+
+	entries, err := listTar(io.TeeReader(content, tmp))
+	if err != nil {
+		_ = tmp.Close()
+		return nil, err
+	}
+
+	var sources []source
+	for _, entry := range entries {
+		entry := entry
+		sources = append(sources, source{
+			name: path.Join(name, entry.name),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(tmp, entry.offset, entry.size)), nil
+			},
+		})
+	}
+	return sources, nil
+}
+
+// listTar reads the tar archive once and returns the regular files in it.
+func listTar(r io.Reader) ([]tarEntry, error) {
+	// the tar reader reads the headers block by block, so the position after a header is the offset of the content
+	counter := &countingReader{reader: r}
+	archive := tar.NewReader(counter)
+
+	var entries []tarEntry
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entries = append(entries, tarEntry{
+			name:   header.Name,
+			offset: counter.n,
+			size:   header.Size,
+		})
+	}
+}
+
+// openTarEntry opens the archive and returns a reader for the content of the entry. Files which do not support
+// seeking are read up to the entry.
+func openTarEntry(open func() (io.ReadCloser, error), entry tarEntry) (io.ReadCloser, error) {
+	file, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(entry.offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, entry.offset)
+	}
+	if err != nil {
+		_ = file.Close()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return readCloser{Reader: io.LimitReader(file, entry.size), close: file.Close}, nil
+}
+
+func getZipSources(name string, open func() (io.ReadCloser, error)) ([]source, error) {
+	archive, closeArchive, err := openZip(open)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive()
+
+	var sources []source
+	for i, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		index := i
+		sources = append(sources, source{
+			name: path.Join(name, file.Name),
+			open: func() (io.ReadCloser, error) {
+				return openZipEntry(open, index)
+			},
+		})
+	}
+	return sources, nil
+}
+
+// openZip opens a zip archive. Files which do not support random access are read into memory.
+func openZip(open func() (io.ReadCloser, error)) (*zip.Reader, func() error, error) {
+	file, err := open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var content io.ReaderAt
+	var size int64
+	if f, ok := file.(interface {
+		io.ReaderAt
+		Stat() (fs.FileInfo, error)
+	}); ok {
+		info, err := f.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		content, size = f, info.Size()
+	} else {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		content, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	archive, err := zip.NewReader(content, size)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return archive, file.Close, nil
+}
+
+func openZipEntry(open func() (io.ReadCloser, error), index int) (io.ReadCloser, error) {
+	archive, closeArchive, err := openZip(open)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := archive.File[index].Open()
+	if err != nil {
+		_ = closeArchive()
+		return nil, err
+	}
+	return readCloser{
+		Reader: entry,
+		close: func() error {
+			_ = entry.Close()
+			return closeArchive()
+		},
+	}, nil
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tarArchive encodes a tar archive with a directory and the files.
+func tarArchive(t *testing.T, files map[string]string, names ...string) []byte {
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	require.NoError(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "rrc00/", Mode: 0755}))
+	for _, name := range names {
+		require.NoError(t, w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name]))}))
+		_, err := w.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+// seekableFile is a file which supports seeking like the files of the file system.
+type seekableFile struct {
+	*bytes.Reader
+}
+
+func (seekableFile) Close() error {
+	return nil
+}
+
+func testTarSources(t *testing.T, open func() (io.ReadCloser, error), files map[string]string) {
+	sources, err := getSources("archive", open)
+	require.NoError(t, err)
+	require.Len(t, sources, len(files))
+
+	// the entries are opened in reverse order and repeatedly
+	for i := len(sources) - 1; i >= 0; i-- {
+		for j := 0; j < 2; j++ {
+			r, err := sources[i].open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, files[strings.TrimPrefix(sources[i].name, "archive/")], string(content))
+		}
+	}
+}
+
+func TestGetSources_Tar(t *testing.T) {
+	files := map[string]string{
+		"rrc00/bview.20220205.0000": "first",
+		// the long name is stored in an additional header
+		"rrc00/" + strings.Repeat("x", 120) + "/updates.20220205.0000": strings.Repeat("second", 200),
+		"rrc00/updates.20220205.0005":                                  "",
+		"rrc00/updates.20220205.0010":                                  "last",
+	}
+	data := tarArchive(t, files, "rrc00/bview.20220205.0000", "rrc00/"+strings.Repeat("x", 120)+"/updates.20220205.0000",
+		"rrc00/updates.20220205.0005", "rrc00/updates.20220205.0010")
+
+	t.Run("seekable", func(t *testing.T) {
+		testTarSources(t, func() (io.ReadCloser, error) {
+			return seekableFile{bytes.NewReader(data)}, nil
+		}, files)
+	})
+	t.Run("stream", func(t *testing.T) {
+		testTarSources(t, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}, files)
+	})
+
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	t.Run("gzip", func(t *testing.T) {
+		opened := 0
+		testTarSources(t, func() (io.ReadCloser, error) {
+			opened++
+			return io.NopCloser(bytes.NewReader(gzipped.Bytes())), nil
+		}, files)
+		// detecting the archive type and listing the files, the files are read from the temporary file
+		assert.Equal(t, 2, opened)
+	})
+}
@@ -0,0 +1,228 @@
+// Package rir looks up the allocation status of prefixes and ASNs in the delegation files of the regional internet
+// registries (RIRs).
+package rir
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status values of the delegation files. StatusUnregistered is used for resources which are not listed in any file.
+const (
+	StatusAllocated    = "allocated"
+	StatusAssigned     = "assigned"
+	StatusAvailable    = "available"
+	StatusReserved     = "reserved"
+	StatusUnregistered = "unregistered"
+)
+
+// Allocation is the delegation of a prefix or ASN by a RIR.
+type Allocation struct {
+	// Status is "allocated" or "assigned" if the resource is in use, otherwise "available", "reserved" or
+	// "unregistered".
+	Status  string `json:"status"`
+	RIR     string `json:"rir,omitempty"`
+	Country string `json:"country,omitempty"`
+	// Date is the date of the allocation (YYYY-MM-DD).
+	Date string `json:"date,omitempty"`
+}
+
+// IsAllocated returns true if the resource was allocated or assigned by a RIR.
+func (a Allocation) IsAllocated() bool {
+	return a.Status == StatusAllocated || a.Status == StatusAssigned
+}
+
+// Delegations contains the records of the delegation files.
+type Delegations struct {
+	asns      []record
+	addresses []record
+}
+
+// record is a range of ASNs or addresses. Addresses are stored as IPv6 addresses, IPv4 addresses as IPv4-mapped
+// addresses.
+type record struct {
+	first      *big.Int
+	last       *big.Int
+	allocation Allocation
+}
+
+// Load reads the delegated-extended files of the RIRs, e.g. delegated-ripencc-extended-latest.
+func Load(paths []string) (*Delegations, error) {
+	delegations := &Delegations{}
+	for _, path := range paths {
+		err := delegations.load(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading delegation file '%s' failed", path)
+		}
+	}
+
+	sortRecords(delegations.asns)
+	sortRecords(delegations.addresses)
+	return delegations, nil
+}
+
+func (d *Delegations) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening file failed")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// registry|cc|type|start|value|date|status[|opaque-id[|extensions...]]
+		fields := strings.Split(text, "|")
+		if len(fields) < 7 || fields[1] == "*" {
+			// version and summary lines
+			continue
+		}
+
+		rec, err := parseRecord(fields)
+		if err != nil {
+			return errors.Wrapf(err, "invalid record in line %d", line)
+		}
+		switch fields[2] {
+		case "asn":
+			d.asns = append(d.asns, rec)
+		case "ipv4", "ipv6":
+			d.addresses = append(d.addresses, rec)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseRecord(fields []string) (record, error) {
+	rec := record{
+		allocation: Allocation{
+			Status:  fields[6],
+			RIR:     fields[0],
+			Country: fields[1],
+		},
+	}
+	if date, err := time.Parse("20060102", fields[5]); err == nil {
+		rec.allocation.Date = date.Format("2006-01-02")
+	}
+
+	value, err := strconv.ParseUint(fields[4], 10, 64)
+	if err != nil || value == 0 {
+		return record{}, errors.Errorf("invalid value '%s'", fields[4])
+	}
+
+	switch fields[2] {
+	case "asn":
+		first, err := strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			return record{}, errors.Wrap(err, "invalid ASN")
+		}
+		rec.first = new(big.Int).SetUint64(first)
+		rec.last = new(big.Int).SetUint64(first + value - 1)
+	case "ipv4":
+		// the value is the number of addresses, which is not necessarily a power of two
+		ip := net.ParseIP(fields[3])
+		if ip == nil || ip.To4() == nil {
+			return record{}, errors.Errorf("invalid IPv4 address '%s'", fields[3])
+		}
+		rec.first = addressToInt(ip)
+		rec.last = new(big.Int).Add(rec.first, new(big.Int).SetUint64(value-1))
+	case "ipv6":
+		// the value is the prefix length
+		ip := net.ParseIP(fields[3])
+		if ip == nil || ip.To4() != nil || value > 128 {
+			return record{}, errors.Errorf("invalid IPv6 prefix '%s/%s'", fields[3], fields[4])
+		}
+		rec.first = addressToInt(ip)
+		size := new(big.Int).Lsh(big.NewInt(1), uint(128-value))
+		rec.last = new(big.Int).Add(rec.first, size.Sub(size, big.NewInt(1)))
+	}
+	return rec, nil
+}
+
+func sortRecords(records []record) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].first.Cmp(records[j].first) < 0
+	})
+}
+
+// lookup returns the allocation of the record which contains value.
+func lookup(records []record, value *big.Int) Allocation {
+	// index of the first record which starts after value
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].first.Cmp(value) > 0
+	})
+	if i > 0 && records[i-1].last.Cmp(value) >= 0 {
+		return records[i-1].allocation
+	}
+	return Allocation{Status: StatusUnregistered}
+}
+
+// lookupRange returns the allocation of the range from first to last. If the records of the range are allocated or
+// assigned, this is the allocation of the record which contains first. Otherwise, it is the allocation of the first
+// part of the range which is not allocated or assigned, i.e. StatusUnregistered if it is not contained in any record.
+func lookupRange(records []record, first, last *big.Int) Allocation {
+	// index of the first record which starts after first
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].first.Cmp(first) > 0
+	})
+	if i > 0 && records[i-1].last.Cmp(first) >= 0 {
+		i--
+	}
+
+	var allocation *Allocation
+	for next := first; next.Cmp(last) <= 0; i++ {
+		if i == len(records) || records[i].first.Cmp(next) > 0 {
+			return Allocation{Status: StatusUnregistered}
+		}
+		if records[i].last.Cmp(next) < 0 {
+			// overlapping record
+			continue
+		}
+		if !records[i].allocation.IsAllocated() {
+			return records[i].allocation
+		}
+		if allocation == nil {
+			allocation = &records[i].allocation
+		}
+		next = new(big.Int).Add(records[i].last, big.NewInt(1))
+	}
+	return *allocation
+}
+
+// LookupASN returns the allocation of the ASN. ok is false if the ASN can not be parsed, e.g. an AS set.
+func (d *Delegations) LookupASN(asn string) (allocation Allocation, ok bool) {
+	parsed, err := strconv.ParseUint(asn, 10, 32)
+	if err != nil {
+		return Allocation{}, false
+	}
+	return lookup(d.asns, new(big.Int).SetUint64(parsed)), true
+}
+
+// LookupPrefix returns the allocation of the prefix. A prefix is only allocated or assigned if all of its addresses
+// are, otherwise the allocation of the first part of the prefix which is not allocated or assigned is returned. ok is
+// false if the prefix can not be parsed.
+func (d *Delegations) LookupPrefix(prefix string) (allocation Allocation, ok bool) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return Allocation{}, false
+	}
+	ones, bits := network.Mask.Size()
+	first := addressToInt(network.IP)
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last := new(big.Int).Add(first, size.Sub(size, big.NewInt(1)))
+	return lookupRange(d.addresses, first, last), true
+}
+
+func addressToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip.To16())
+}
@@ -0,0 +1,107 @@
+package rir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const delegatedExtended = `2|ripencc|1644015599|123|19830705|20220204|+0100
+ripencc|*|asn|*|3|summary
+ripencc|*|ipv4|*|3|summary
+ripencc|*|ipv6|*|2|summary
+ripencc|NL|asn|3333|1|19930901|allocated|a1b2c3
+ripencc|ZZ|asn|3334|2||available|
+ripencc|DE|asn|64000|1|20100101|reserved|
+ripencc|NL|ipv4|193.0.0.0|2048|19930901|allocated|a1b2c3
+ripencc|ZZ|ipv4|193.0.8.0|768|00000000|available|
+ripencc|EU|ipv4|193.0.11.0|256|20010101|assigned|d4e5f6
+ripencc|NL|ipv6|2001:67c::|32|19990826|allocated|a1b2c3
+ripencc|ZZ|ipv6|2001:67d::|32||available|
+`
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delegated-ripencc-extended-latest")
+	assert.NoError(t, os.WriteFile(path, []byte(delegatedExtended), 0644))
+
+	delegations, err := Load([]string{path})
+	assert.NoError(t, err)
+
+	allocation, ok := delegations.LookupASN("3333")
+	assert.True(t, ok)
+	assert.Equal(t, Allocation{Status: StatusAllocated, RIR: "ripencc", Country: "NL", Date: "1993-09-01"}, allocation)
+	assert.True(t, allocation.IsAllocated())
+
+	allocation, _ = delegations.LookupASN("3335")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	assert.False(t, allocation.IsAllocated())
+	allocation, _ = delegations.LookupASN("64000")
+	assert.Equal(t, StatusReserved, allocation.Status)
+	allocation, _ = delegations.LookupASN("3336")
+	assert.Equal(t, Allocation{Status: StatusUnregistered}, allocation)
+	_, ok = delegations.LookupASN("{3333,3334}")
+	assert.False(t, ok)
+
+	allocation, _ = delegations.LookupPrefix("193.0.7.0/24")
+	assert.Equal(t, StatusAllocated, allocation.Status)
+	// 768 addresses are no CIDR block
+	allocation, _ = delegations.LookupPrefix("193.0.10.0/24")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("193.0.11.0/24")
+	assert.Equal(t, Allocation{Status: StatusAssigned, RIR: "ripencc", Country: "EU", Date: "2001-01-01"}, allocation)
+	allocation, _ = delegations.LookupPrefix("193.0.12.0/24")
+	assert.Equal(t, StatusUnregistered, allocation.Status)
+
+	// the whole prefix has to be allocated or assigned
+	allocation, _ = delegations.LookupPrefix("193.0.0.0/21")
+	assert.Equal(t, Allocation{Status: StatusAllocated, RIR: "ripencc", Country: "NL", Date: "1993-09-01"}, allocation)
+	allocation, _ = delegations.LookupPrefix("193.0.0.0/20")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("193.0.11.0/24")
+	assert.Equal(t, StatusAssigned, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("193.0.10.0/23")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("193.0.0.0/16")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("193.0.12.0/22")
+	assert.Equal(t, StatusUnregistered, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("2001:67c::/31")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("2001:67c::/30")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+	_, ok = delegations.LookupPrefix("193.0.0.0")
+	assert.False(t, ok)
+
+	allocation, _ = delegations.LookupPrefix("2001:67c:2e8::/48")
+	assert.Equal(t, StatusAllocated, allocation.Status)
+	allocation, _ = delegations.LookupPrefix("2001:67d:1::/48")
+	assert.Equal(t, StatusAvailable, allocation.Status)
+}
+
+func TestLookupPrefix_AdjacentAllocations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delegated")
+	assert.NoError(t, os.WriteFile(path, []byte(`ripencc|NL|ipv4|193.0.0.0|1024|19930901|allocated|
+ripencc|NL|ipv4|193.0.4.0|1024|20010101|assigned|
+ripencc|NL|ipv4|193.0.12.0|1024|20010101|allocated|
+`), 0644))
+	delegations, err := Load([]string{path})
+	assert.NoError(t, err)
+
+	// a prefix which covers multiple allocations is allocated like its first address
+	allocation, _ := delegations.LookupPrefix("193.0.0.0/21")
+	assert.Equal(t, Allocation{Status: StatusAllocated, RIR: "ripencc", Country: "NL", Date: "1993-09-01"}, allocation)
+	// the gap between the allocations is not registered
+	allocation, _ = delegations.LookupPrefix("193.0.0.0/20")
+	assert.Equal(t, Allocation{Status: StatusUnregistered}, allocation)
+	allocation, _ = delegations.LookupPrefix("192.0.0.0/8")
+	assert.Equal(t, Allocation{Status: StatusUnregistered}, allocation)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delegated")
+	assert.NoError(t, os.WriteFile(path, []byte("ripencc|NL|ipv4|193.0.0|256|19930901|allocated|\n"), 0644))
+
+	_, err := Load([]string{path})
+	assert.Error(t, err)
+}
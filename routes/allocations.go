@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"github.com/TheFireMike/moasDetector/rir"
+	"sort"
+)
+
+// UnallocatedRoute is a route to a prefix or of an origin AS which was not allocated or assigned by a RIR.
+type UnallocatedRoute struct {
+	Prefix           string          `json:"prefix"`
+	OriginAS         string          `json:"origin_as"`
+	PrefixAllocation rir.Allocation  `json:"prefix_allocation"`
+	OriginAllocation *rir.Allocation `json:"origin_allocation,omitempty"`
+	Visibility       []Peer          `json:"visibility"`
+}
+
+// annotateAllocations adds the allocations of the prefixes and origin ASes to the MOAS prefixes.
+func annotateAllocations(moas []MOASPrefix, delegations *rir.Delegations) {
+	for i := range moas {
+		if allocation, ok := delegations.LookupPrefix(moas[i].Prefix); ok {
+			moas[i].Allocation = &allocation
+		}
+		for j := range moas[i].Origin {
+			if allocation, ok := delegations.LookupASN(moas[i].Origin[j].AS); ok {
+				moas[i].Origin[j].Allocation = &allocation
+			}
+		}
+	}
+}
+
+// getUnallocatedRoutes returns all routes to prefixes or of origin ASes which were not allocated or assigned. AS sets
+// are not checked.
+func (r *routeData) getUnallocatedRoutes(delegations *rir.Delegations) []UnallocatedRoute {
+	var unallocated []UnallocatedRoute
+
+	for prefix, origins := range r.prefixes {
+		prefixAllocation, ok := delegations.LookupPrefix(prefix)
+		if !ok {
+			continue
+		}
+		for origin, feeders := range origins {
+			route := UnallocatedRoute{
+				Prefix:           prefix,
+				OriginAS:         origin,
+				PrefixAllocation: prefixAllocation,
+			}
+			if originAllocation, ok := delegations.LookupASN(origin); ok {
+				route.OriginAllocation = &originAllocation
+			}
+
+			if prefixAllocation.IsAllocated() && (route.OriginAllocation == nil || route.OriginAllocation.IsAllocated()) {
+				continue
+			}
+			route.Visibility = getSortedPeers(feeders)
+			unallocated = append(unallocated, route)
+		}
+	}
+
+	return unallocated
+}
+
+func sortUnallocatedRoutes(routes []UnallocatedRoute) {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Prefix == routes[j].Prefix {
+			return routes[i].OriginAS < routes[j].OriginAS
+		}
+		return routes[i].Prefix < routes[j].Prefix
+	})
+}
@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"sort"
+	"time"
+)
+
+// MOASEvent describes a period of time in which a prefix was announced by more than one origin AS.
+type MOASEvent struct {
+	Prefix string    `json:"prefix"`
+	Start  time.Time `json:"start"`
+	// End is nil if the conflict was not resolved until the end of the replay, which is always the case without replay.
+	End *time.Time `json:"end"`
+	// Duration is the length of the event in seconds. It is nil if the conflict was not resolved.
+	Duration *int64 `json:"duration"`
+	// Origin contains every origin AS which was announced during the event and the peers which received it.
+	Origin []MOASPrefixOrigin `json:"origin"`
+}
+
+type moasEvent struct {
+	prefix  string
+	start   time.Time
+	end     time.Time
+	origins map[string]map[Peer]struct{}
+}
+
+// updateEvent opens, extends or closes the MOAS event of the prefix according to its current origins.
+func (r *routeData) updateEvent(prefix string, timestamp time.Time) {
+	origins := r.prefixes[prefix]
+	event, ongoing := r.events[prefix]
+
+	if len(origins) < 2 {
+		if ongoing {
+			event.end = timestamp
+			r.pastEvents = append(r.pastEvents, event)
+			delete(r.events, prefix)
+		}
+		return
+	}
+
+	if !ongoing {
+		event = &moasEvent{
+			prefix:  prefix,
+			start:   timestamp,
+			origins: make(map[string]map[Peer]struct{}),
+		}
+		r.events[prefix] = event
+	}
+
+	for origin, peers := range origins {
+		eventPeers, ok := event.origins[origin]
+		if !ok {
+			eventPeers = make(map[Peer]struct{})
+			event.origins[origin] = eventPeers
+		}
+		for peer := range peers {
+			eventPeers[peer] = struct{}{}
+		}
+	}
+}
+
+func (r *routeData) getMOASEvents() []MOASEvent {
+	if !r.replay {
+		return r.getObservedMOASEvents()
+	}
+
+	var events []MOASEvent
+
+	for _, event := range r.pastEvents {
+		events = append(events, event.toMOASEvent())
+	}
+	for _, event := range r.events {
+		events = append(events, event.toMOASEvent())
+	}
+
+	return events
+}
+
+// getObservedMOASEvents returns the MOAS events without replay. As withdrawals are not applied, the conflict of every
+// MOAS prefix lasts until the end of the input, so its event starts with the first announcement of its second origin
+// and has no end. This does not depend on the order in which the announcements were handled.
+func (r *routeData) getObservedMOASEvents() []MOASEvent {
+	var events []MOASEvent
+
+	for prefix, origins := range r.prefixes {
+		if len(origins) < 2 {
+			continue
+		}
+
+		event := &moasEvent{
+			prefix:  prefix,
+			origins: make(map[string]map[Peer]struct{}),
+		}
+		var starts []time.Time
+		for origin, feeders := range origins {
+			starts = append(starts, r.firstAnnounced[prefix][origin])
+			peers := make(map[Peer]struct{})
+			for peer := range feeders {
+				peers[peer] = struct{}{}
+			}
+			event.origins[origin] = peers
+		}
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i].Before(starts[j])
+		})
+		event.start = starts[1]
+		events = append(events, event.toMOASEvent())
+	}
+
+	return events
+}
+
+func (e *moasEvent) toMOASEvent() MOASEvent {
+	event := MOASEvent{
+		Prefix: e.prefix,
+		Start:  e.start,
+	}
+
+	if !e.end.IsZero() {
+		end := e.end
+		duration := int64(e.end.Sub(e.start).Seconds())
+		event.End = &end
+		event.Duration = &duration
+	}
+
+	for origin, peers := range e.origins {
+		eventOrigin := MOASPrefixOrigin{
+			AS: origin,
+		}
+		for peer := range peers {
+			eventOrigin.Visibility = append(eventOrigin.Visibility, peer)
+		}
+		sortPeers(eventOrigin.Visibility)
+		event.Origin = append(event.Origin, eventOrigin)
+	}
+	sort.Slice(event.Origin, func(i, j int) bool {
+		return event.Origin[i].AS < event.Origin[j].AS
+	})
+
+	return event
+}
+
+func sortMOASEvents(events []MOASEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Start.Equal(events[j].Start) {
+			return events[i].Prefix < events[j].Prefix
+		}
+		return events[i].Start.Before(events[j].Start)
+	})
+}
@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRouteData_MOASEvents(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+	start := time.Date(2022, 2, 5, 8, 0, 0, 0, time.UTC)
+
+	r := newRouteData(true)
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: peer, Timestamp: start})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "3333", ReceivedBy: peer, Timestamp: start})
+	assert.Empty(t, r.getMOASEvents())
+
+	// the second origin starts the events, the withdrawal ends the first one
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: other, Timestamp: start.Add(time.Minute)})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "1103", ReceivedBy: other, Timestamp: start.Add(2 * time.Minute)})
+	r.handleAnnouncement(RouteAnnouncement{Type: Withdraw, Prefix: "193.0.0.0/21", ReceivedBy: other, Timestamp: start.Add(3 * time.Minute)})
+
+	events := r.getMOASEvents()
+	sortMOASEvents(events)
+	end := start.Add(3 * time.Minute)
+	duration := int64(120)
+	assert.Equal(t, []MOASEvent{{
+		Prefix:   "193.0.0.0/21",
+		Start:    start.Add(time.Minute),
+		End:      &end,
+		Duration: &duration,
+		Origin: []MOASPrefixOrigin{
+			{AS: "13335", Visibility: []Peer{other}},
+			{AS: "3333", Visibility: []Peer{peer}},
+		},
+	}, {
+		Prefix: "193.0.8.0/23",
+		Start:  start.Add(2 * time.Minute),
+		Origin: []MOASPrefixOrigin{
+			{AS: "1103", Visibility: []Peer{other}},
+			{AS: "3333", Visibility: []Peer{peer}},
+		},
+	}}, events)
+
+	// a session reset ends the second event
+	r.handleAnnouncement(RouteAnnouncement{Type: SessionDown, ReceivedBy: other, Timestamp: start.Add(5 * time.Minute)})
+	events = r.getMOASEvents()
+	sortMOASEvents(events)
+	if assert.Len(t, events, 2) && assert.NotNil(t, events[1].End) {
+		assert.Equal(t, start.Add(5*time.Minute), *events[1].End)
+		assert.Equal(t, int64(180), *events[1].Duration)
+	}
+}
+
+func TestRouteData_ObservedMOASEvents(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+	start := time.Date(2022, 2, 5, 8, 0, 0, 0, time.UTC)
+
+	announcements := []RouteAnnouncement{
+		{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: peer, Timestamp: start},
+		{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: other, Timestamp: start.Add(2 * time.Minute)},
+		{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: peer, Timestamp: start.Add(time.Minute)},
+		{Type: Withdraw, Prefix: "193.0.0.0/21", ReceivedBy: peer, Timestamp: start.Add(3 * time.Minute)},
+		{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "3333", ReceivedBy: peer, Timestamp: start},
+	}
+
+	// without replay, the event of a MOAS prefix starts with the first announcement of its second origin, regardless of
+	// the order of the announcements, and is not ended by withdrawals
+	for _, reverse := range []bool{false, true} {
+		r := newRouteData(false)
+		for i := range announcements {
+			if reverse {
+				i = len(announcements) - 1 - i
+			}
+			r.handleAnnouncement(announcements[i])
+		}
+
+		assert.Equal(t, []MOASEvent{{
+			Prefix: "193.0.0.0/21",
+			Start:  start.Add(time.Minute),
+			Origin: []MOASPrefixOrigin{
+				{AS: "13335", Visibility: []Peer{other, peer}},
+				{AS: "3333", Visibility: []Peer{peer}},
+			},
+		}}, r.getMOASEvents())
+	}
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"sort"
+	"sync"
+)
+
+// Reasons of filtered announcements, used as keys of the filtered routes report and statistics.
+const (
+	// FilterReasonInvalidPrefix is used for prefixes with a prefix length of 0 or host bits.
+	FilterReasonInvalidPrefix = "invalid_prefix"
+	// FilterReasonReservedPrefix is used for prefixes of the special-purpose address registries.
+	FilterReasonReservedPrefix = "reserved_prefix"
+	// FilterReasonDeniedPrefix is used for prefixes of the deny list.
+	FilterReasonDeniedPrefix = "denied_prefix"
+	// FilterReasonTooShortPrefix is used for prefixes which are shorter than the minimum length of their address
+	// family.
+	FilterReasonTooShortPrefix = "too_short_prefix"
+	// FilterReasonTooSpecificPrefix is used for prefixes which are longer than the maximum length of their address
+	// family, unless they are analyzed separately.
+	FilterReasonTooSpecificPrefix = "too_specific_prefix"
+	// FilterReasonReservedASN is used for origin ASes of the special-purpose AS number registry.
+	FilterReasonReservedASN = "reserved_asn"
+	// FilterReasonDeniedASN is used for origin ASes of the deny list.
+	FilterReasonDeniedASN = "denied_asn"
+	// FilterReasonInvalidASN is used for origin ASes which are no number.
+	FilterReasonInvalidASN = "invalid_asn"
+	// FilterReasonInvalidASSet is used for AS sets at the end of the AS path without any valid AS.
+	FilterReasonInvalidASSet = "invalid_as_set"
+	// FilterReasonMissingASPath is used for routes without AS_PATH attribute.
+	FilterReasonMissingASPath = "missing_as_path"
+	// FilterReasonEmptyASPath is used for AS paths without any AS outside of confederation segments.
+	FilterReasonEmptyASPath = "empty_as_path"
+	// FilterReasonUnknownSegment is used for AS paths with an unknown segment type.
+	FilterReasonUnknownSegment = "unknown_as_path_segment"
+)
+
+// FilteredAnnouncement is an announced route which was not processed. Every route is only passed once per file, no
+// matter how often it was announced.
+type FilteredAnnouncement struct {
+	Reason string
+	Prefix string
+	// OriginAS is the origin AS of the AS path as far as it is known, it is empty if the AS path is missing or empty.
+	OriginAS   string
+	ReceivedBy Peer
+	Multicast  bool
+}
+
+// FilteredRoute is a route which was filtered for the same reason at all peers in Visibility.
+type FilteredRoute struct {
+	Prefix     string `json:"prefix"`
+	OriginAS   string `json:"origin_as,omitempty"`
+	Multicast  bool   `json:"multicast,omitempty"`
+	Visibility []Peer `json:"visibility"`
+}
+
+// filteredKey identifies a filtered route regardless of the receiving peer.
+type filteredKey struct {
+	reason    string
+	prefix    string
+	originAS  string
+	multicast bool
+}
+
+func (r *Routes) handleFiltered(filteredChan chan []FilteredAnnouncement, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for announcements := range filteredChan {
+		for _, announcement := range announcements {
+			key := filteredKey{
+				reason:    announcement.Reason,
+				prefix:    announcement.Prefix,
+				originAS:  announcement.OriginAS,
+				multicast: announcement.Multicast,
+			}
+			feeders, ok := r.filtered[key]
+			if !ok {
+				feeders = make(map[Peer]struct{})
+				r.filtered[key] = feeders
+			}
+			feeders[announcement.ReceivedBy] = struct{}{}
+		}
+	}
+}
+
+// getFilteredRoutes returns the filtered routes grouped by reason.
+func (r *Routes) getFilteredRoutes() map[string][]FilteredRoute {
+	filtered := make(map[string][]FilteredRoute)
+	for key, feeders := range r.filtered {
+		route := FilteredRoute{
+			Prefix:    key.prefix,
+			OriginAS:  key.originAS,
+			Multicast: key.multicast,
+		}
+		for peer := range feeders {
+			route.Visibility = append(route.Visibility, peer)
+		}
+		sortPeers(route.Visibility)
+		filtered[key.reason] = append(filtered[key.reason], route)
+	}
+
+	for _, routes := range filtered {
+		sort.Slice(routes, func(i, j int) bool {
+			if routes[i].Prefix != routes[j].Prefix {
+				return routes[i].Prefix < routes[j].Prefix
+			}
+			if routes[i].OriginAS != routes[j].OriginAS {
+				return routes[i].OriginAS < routes[j].OriginAS
+			}
+			return !routes[i].Multicast && routes[j].Multicast
+		})
+	}
+	return filtered
+}
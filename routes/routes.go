@@ -2,139 +2,799 @@ package routes
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/TheFireMike/moasDetector/rir"
 	"github.com/pkg/errors"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Routes struct {
-	routesIPv4 routeData
-	routesIPv6 routeData
-	peers      []Peer
+	routesIPv4          routeView
+	routesIPv6          routeView
+	routesIPv4Multicast routeView
+	routesIPv6Multicast routeView
+	replay              bool
+	delegations         *rir.Delegations
+	peers               []Peer
+	sessionResets       map[Peer]int
+	files               []FileStatistics
+	// filtered contains the peers which received a filtered route
+	filtered map[filteredKey]map[Peer]struct{}
+
+	// routesIPv4TooSpecific and routesIPv6TooSpecific contain the unicast routes of prefixes which are longer than the
+	// maximum length, they are only filled if too specific prefixes are analyzed
+	routesIPv4TooSpecific routeView
+	routesIPv6TooSpecific routeView
+	tooSpecific           bool
 }
 
 type routeData struct {
-	prefixes map[string]map[string][]Peer
+	// prefixes maps every prefix to its origin ASes, the peers which received a route to the origin and the path IDs
+	// of these routes
+	prefixes map[string]map[string]map[Peer][]uint32
+	// attributes contains the attributes of the latest announcement of every route
+	attributes map[routeKey]announcedAttributes
+	// addPathPeers contains all peers which sent routes with ADD-PATH path IDs
+	addPathPeers  map[Peer]struct{}
+	replay        bool
+	announcements int
+	withdrawals   int
+
+	// peerPrefixes maps every peer to the prefixes it has routes to and the origins of these routes to the time of
+	// the last announcement, so the routes of a peer can be removed without scanning all prefixes
+	peerPrefixes map[Peer]map[string]map[string]time.Time
+	// sessionDowns contains the time of the last session reset of every peer. Without replay, the session resets are
+	// only applied after all announcements were handled, see applySessionResets.
+	sessionDowns map[Peer]time.Time
+
+	// MOAS events are tracked in replay mode, in which the announcements are handled in time order
+	events     map[string]*moasEvent
+	pastEvents []*moasEvent
+	// firstAnnounced contains the time of the first announcement of every origin of a prefix. It is only kept without
+	// replay, where the MOAS events are derived from it after all announcements were handled.
+	firstAnnounced map[string]map[string]time.Time
 }
 
 type MOASPrefix struct {
 	Prefix string             `json:"prefix"`
 	Origin []MOASPrefixOrigin `json:"origin"`
+	// AddPathPeers contains the peers which received paths to more than one origin of the prefix in their ADD-PATH
+	// set, so the MOAS is visible within a single peer.
+	AddPathPeers []Peer `json:"add_path_peers,omitempty"`
+	// Allocation is the allocation of the prefix by a RIR. It is only set if delegation files are loaded.
+	Allocation *rir.Allocation `json:"allocation,omitempty"`
 }
 
 type MOASPrefixOrigin struct {
 	AS         string `json:"as"`
 	Visibility []Peer `json:"visibility"`
+	// PathIDs contains the ADD-PATH path IDs of the paths to the origin for every peer in Visibility which sent
+	// routes with path IDs.
+	PathIDs []PeerPathIDs `json:"path_ids,omitempty"`
+	// Routes contains the attributes of the route of every peer in Visibility. It is only set if the announcements
+	// carry route attributes.
+	Routes []Route `json:"routes,omitempty"`
+	// Allocation is the allocation of the origin AS by a RIR. It is only set if delegation files are loaded and the
+	// origin is not an AS set.
+	Allocation *rir.Allocation `json:"allocation,omitempty"`
+}
+
+// PeerPathIDs are the ADD-PATH path IDs of the paths of a peer.
+type PeerPathIDs struct {
+	Peer    Peer     `json:"peer"`
+	PathIDs []uint32 `json:"path_ids"`
+}
+
+// Route is the route of a peer to an origin AS.
+type Route struct {
+	Peer Peer `json:"peer"`
+	// PathID is the ADD-PATH path ID of the route, if the peer sent routes with path IDs.
+	PathID *uint32 `json:"path_id,omitempty"`
+	RouteAttributes
 }
 
+// RouteAttributes are the BGP path attributes of a route.
+type RouteAttributes struct {
+	// ASPath is the AS path separated by spaces, AS sets are enclosed in curly braces (e.g. "3333 1103 {1,2}").
+	ASPath string `json:"as_path"`
+	// NextHop is the next hop of the NEXT_HOP or MP_REACH_NLRI attribute.
+	NextHop string `json:"next_hop"`
+	// Origin is the ORIGIN attribute ("IGP", "EGP" or "INCOMPLETE").
+	Origin           string   `json:"origin"`
+	Communities      []string `json:"communities,omitempty"`
+	LargeCommunities []string `json:"large_communities,omitempty"`
+}
+
+// announcedAttributes are the attributes of a route and the time they were announced.
+type announcedAttributes struct {
+	attributes *RouteAttributes
+	timestamp  time.Time
+}
+
+// routeKey identifies the route of a peer to an origin AS.
+type routeKey struct {
+	prefix string
+	origin string
+	peer   Peer
+	pathID uint32
+}
+
+// Peer is a BGP neighbor of a route collector. The same router peering with multiple collectors results in multiple
+// peers.
 type Peer struct {
 	AS string `json:"as"`
 	IP string `json:"ip"`
+	// Collector is the name of the route collector of the file which contained the route, see
+	// FileStatistics.Collector.
+	Collector string `json:"collector"`
 }
 
 type Statistics struct {
-	IPv4Prefixes     int              `json:"ipv4_prefixes"`
-	IPv6Prefixes     int              `json:"ipv6_prefixes"`
-	IPv4MOASPrefixes int              `json:"ipv4_moas_prefixes"`
-	IPv6MOASPrefixes int              `json:"ipv6_moas_prefixes"`
-	Peers            []PeerStatistics `json:"peers"`
+	IPv4Prefixes      int `json:"ipv4_prefixes"`
+	IPv6Prefixes      int `json:"ipv6_prefixes"`
+	IPv4MOASPrefixes  int `json:"ipv4_moas_prefixes"`
+	IPv6MOASPrefixes  int `json:"ipv6_moas_prefixes"`
+	IPv4Announcements int `json:"ipv4_announcements"`
+	IPv6Announcements int `json:"ipv6_announcements"`
+	IPv4Withdrawals   int `json:"ipv4_withdrawals"`
+	IPv6Withdrawals   int `json:"ipv6_withdrawals"`
+
+	IPv4MulticastPrefixes      int `json:"ipv4_multicast_prefixes"`
+	IPv6MulticastPrefixes      int `json:"ipv6_multicast_prefixes"`
+	IPv4MulticastMOASPrefixes  int `json:"ipv4_multicast_moas_prefixes"`
+	IPv6MulticastMOASPrefixes  int `json:"ipv6_multicast_moas_prefixes"`
+	IPv4MulticastAnnouncements int `json:"ipv4_multicast_announcements"`
+	IPv6MulticastAnnouncements int `json:"ipv6_multicast_announcements"`
+	IPv4MulticastWithdrawals   int `json:"ipv4_multicast_withdrawals"`
+	IPv6MulticastWithdrawals   int `json:"ipv6_multicast_withdrawals"`
+
+	// The prefixes and MOAS prefixes of the too specific unicast routes, which are only set if too specific prefixes
+	// are analyzed.
+	IPv4TooSpecificPrefixes     int `json:"ipv4_too_specific_prefixes,omitempty"`
+	IPv6TooSpecificPrefixes     int `json:"ipv6_too_specific_prefixes,omitempty"`
+	IPv4TooSpecificMOASPrefixes int `json:"ipv4_too_specific_moas_prefixes,omitempty"`
+	IPv6TooSpecificMOASPrefixes int `json:"ipv6_too_specific_moas_prefixes,omitempty"`
+
+	// ObservationStart and ObservationEnd are the time range of the MRT records of all files.
+	ObservationStart *time.Time `json:"observation_start"`
+	ObservationEnd   *time.Time `json:"observation_end"`
+	// DumpSkew is the time in seconds between the first records of the earliest and the latest table dump.
+	DumpSkew int64 `json:"dump_skew"`
+	// ReconstructedASPaths is the number of routes of all files of which the AS path was reconstructed from AS_PATH
+	// and AS4_PATH.
+	ReconstructedASPaths int `json:"reconstructed_as_paths"`
+	// ASPaths contains the shapes of the AS paths of all files.
+	ASPaths ASPathStatistics `json:"as_paths"`
+	// UnallocatedRoutes is the number of routes to prefixes or of origin ASes which are not allocated by a RIR. It is
+	// only set if delegation files are loaded.
+	UnallocatedRoutes int `json:"unallocated_routes,omitempty"`
+	// Filtered is the number of filtered announcements of all files per reason.
+	Filtered map[string]int `json:"filtered"`
+
+	UnknownFormatFiles int              `json:"unknown_format_files"`
+	FailedFiles        int              `json:"failed_files"`
+	TruncatedFiles     int              `json:"truncated_files"`
+	DistantDumpFiles   int              `json:"distant_dump_files"`
+	Files              []FileStatistics `json:"files"`
+
+	Peers      []PeerStatistics      `json:"peers"`
+	Collectors []CollectorStatistics `json:"collectors"`
 }
 
 type PeerStatistics struct {
 	Peer
+	PrefixStatistics
+
+	SessionResets int `json:"session_resets"`
+}
+
+// CollectorStatistics contains the statistics of all peers of a route collector. A prefix is counted once per
+// collector, regardless of how many of its peers received it.
+type CollectorStatistics struct {
+	Collector string `json:"collector"`
+	Peers     int    `json:"peers"`
+	PrefixStatistics
+
+	SessionResets int `json:"session_resets"`
+}
+
+// PrefixStatistics contains the number of prefixes and MOAS prefixes received by a peer or collector.
+type PrefixStatistics struct {
 	IPv4Prefixes     int `json:"ipv4_prefixes"`
 	IPv6Prefixes     int `json:"ipv6_prefixes"`
 	IPv4MOASPrefixes int `json:"ipv4_moas_prefixes"`
 	IPv6MOASPrefixes int `json:"ipv6_moas_prefixes"`
+
+	IPv4MulticastPrefixes     int `json:"ipv4_multicast_prefixes"`
+	IPv6MulticastPrefixes     int `json:"ipv6_multicast_prefixes"`
+	IPv4MulticastMOASPrefixes int `json:"ipv4_multicast_moas_prefixes"`
+	IPv6MulticastMOASPrefixes int `json:"ipv6_multicast_moas_prefixes"`
+}
+
+// FileFormatUnknown is the format of input files which are neither compressed in a supported format nor MRT files.
+const FileFormatUnknown = "unknown"
+
+// FileStatistics contains information about a processed input file.
+type FileStatistics struct {
+	Name string `json:"name"`
+	// Collector is the name of the route collector which recorded the file. It is taken from the directory layout of
+	// RIS (e.g. "rrc00") or RouteViews (e.g. "route-views2"), otherwise from the collector BGP ID and view name of the
+	// peer index table. Update files get the collector of the table dumps in the same directory, if they belong to a
+	// single collector. It is empty if neither is available.
+	Collector string `json:"collector"`
+	// Format is the detected compression format of the file, "mrt" for uncompressed files or "unknown".
+	Format string `json:"format"`
+	// Bytes is the number of (compressed) bytes read from the file.
+	Bytes int64 `json:"bytes"`
+	// Records is the number of MRT records read from the file.
+	Records int `json:"records"`
+	// Duration is the time in seconds the file was open.
+	Duration float64 `json:"duration"`
+	// Throughput is the number of bytes read per second.
+	Throughput float64 `json:"throughput"`
+	// PeerIndexRecords is the number of PEER_INDEX_TABLE records read from the file.
+	PeerIndexRecords int `json:"peer_index_records"`
+	// RIBRecords is the number of RIB records (TABLE_DUMP or TABLE_DUMP_V2) read from the file.
+	RIBRecords int `json:"rib_records"`
+	// ReconstructedASPaths is the number of routes of which the AS path was reconstructed from AS_PATH and AS4_PATH,
+	// because they were received over a session with 2-byte ASNs.
+	ReconstructedASPaths int `json:"reconstructed_as_paths"`
+	// ASPaths contains the shapes of the AS paths of the routes of the file.
+	ASPaths ASPathStatistics `json:"as_paths"`
+	// Filtered is the number of announcements per reason which were not processed, see the FilterReason constants.
+	// Withdrawals of filtered prefixes are not counted.
+	Filtered map[string]int `json:"filtered,omitempty"`
+	// Truncated is set if the file ended unexpectedly or is corrupt. The counters above cover the complete records
+	// before.
+	Truncated bool `json:"truncated"`
+	// ObservationStart and ObservationEnd are the time range of the MRT records of the file.
+	ObservationStart *time.Time `json:"observation_start,omitempty"`
+	ObservationEnd   *time.Time `json:"observation_end,omitempty"`
+	// DumpTime is the time of a table dump, taken from the file name or the first MRT record. It is only set if
+	// snapshots are selected.
+	DumpTime *time.Time `json:"dump_time,omitempty"`
+	// TargetDistance is the distance in seconds between DumpTime and the snapshot time.
+	TargetDistance *int64 `json:"target_distance,omitempty"`
+	// TooFarFromTarget is set if the distance exceeds the tolerance.
+	TooFarFromTarget bool `json:"too_far_from_target,omitempty"`
+	// Error is the error which stopped the processing of the file, the counters above cover the data processed
+	// before the error.
+	Error string `json:"error,omitempty"`
 }
 
+// ASPathStatistics counts the shapes of the AS paths from which the origin AS was determined. Every route is counted
+// in exactly one of Sequence, TrailingASSet, Empty, UnknownSegment and Missing. Confederation, InnerASSet and
+// EmptySegments additionally count the routes of which the AS path contained such segments.
+type ASPathStatistics struct {
+	// Sequence is the number of AS paths ending with an AS_SEQUENCE, the origin is its last AS.
+	Sequence int `json:"sequence"`
+	// TrailingASSet is the number of AS paths ending with an AS_SET, the origin is the set of its valid ASes.
+	TrailingASSet int `json:"trailing_as_set"`
+	// Empty is the number of AS paths without any AS outside of confederation segments. These routes are skipped.
+	Empty int `json:"empty"`
+	// UnknownSegment is the number of AS paths with an unknown segment type. These routes are skipped.
+	UnknownSegment int `json:"unknown_segment"`
+	// Missing is the number of routes without an AS_PATH attribute. These routes are skipped.
+	Missing int `json:"missing"`
+
+	// Confederation is the number of AS paths with AS_CONFED_SEQUENCE or AS_CONFED_SET segments, which were stripped
+	// (RFC 5065).
+	Confederation int `json:"confederation"`
+	// InnerASSet is the number of AS paths with an AS_SET before the last segment.
+	InnerASSet int `json:"inner_as_set"`
+	// EmptySegments is the number of AS paths with segments without ASes, which were skipped.
+	EmptySegments int `json:"empty_segments"`
+}
+
+func (s *ASPathStatistics) add(other ASPathStatistics) {
+	s.Sequence += other.Sequence
+	s.TrailingASSet += other.TrailingASSet
+	s.Empty += other.Empty
+	s.UnknownSegment += other.UnknownSegment
+	s.Missing += other.Missing
+	s.Confederation += other.Confederation
+	s.InnerASSet += other.InnerASSet
+	s.EmptySegments += other.EmptySegments
+}
+
+// ProcessingErrors contains all errors which occurred while processing the input.
+type ProcessingErrors []error
+
+func (e ProcessingErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e), strings.Join(messages, "; "))
+}
+
+type AnnouncementType uint8
+
+const (
+	// Announce marks a route which is present in a table dump or was announced by a BGP update.
+	Announce AnnouncementType = iota
+	// Withdraw marks a route which was withdrawn by a BGP update. OriginAS is not set for withdrawals.
+	Withdraw
+	// SessionDown marks that the BGP session of the receiving peer left the established state, so all of its routes
+	// announced before are withdrawn. Prefix and OriginAS are not set.
+	SessionDown
+)
+
 type RouteAnnouncement struct {
+	Type       AnnouncementType
 	Prefix     string
 	OriginAS   string
 	ReceivedBy Peer
+	Timestamp  time.Time
+	// Attributes are the path attributes of an announced route. They are optional and only kept for the MOAS output.
+	Attributes *RouteAttributes
+	// AddPath is set if the route was received with an ADD-PATH path ID (RFC 7911). A peer can have multiple routes
+	// to a prefix which are distinguished by PathID, otherwise PathID is 0.
+	AddPath bool
+	PathID  uint32
 }
 
+// Channels connect the parser with the routing table. The announcements of every view are sharded by prefix and sent
+// in batches, see Batcher.
 type Channels struct {
-	IPv4   chan RouteAnnouncement
-	IPv6   chan RouteAnnouncement
-	Peers  chan []Peer
-	Errors chan error
+	IPv4          []chan []RouteAnnouncement
+	IPv6          []chan []RouteAnnouncement
+	IPv4Multicast []chan []RouteAnnouncement
+	IPv6Multicast []chan []RouteAnnouncement
+	Peers         chan []Peer
+	SessionResets chan Peer
+	Files         chan FileStatistics
+	Filtered      chan []FilteredAnnouncement
+	Errors        chan error
+
+	// IPv4TooSpecific and IPv6TooSpecific receive the unicast routes of prefixes which are longer than the maximum
+	// length, if they are analyzed separately.
+	IPv4TooSpecific []chan []RouteAnnouncement
+	IPv6TooSpecific []chan []RouteAnnouncement
 }
 
-func NewChannels() Channels {
+// NewChannels creates the channels for the given number of shards per view.
+func NewChannels(shards int) Channels {
+	if shards < 1 {
+		shards = 1
+	}
 	return Channels{
-		IPv4:   make(chan RouteAnnouncement),
-		IPv6:   make(chan RouteAnnouncement),
-		Peers:  make(chan []Peer),
-		Errors: make(chan error),
+		IPv4:          newShardChannels(shards),
+		IPv6:          newShardChannels(shards),
+		IPv4Multicast: newShardChannels(shards),
+		IPv6Multicast: newShardChannels(shards),
+		Peers:         make(chan []Peer),
+		SessionResets: make(chan Peer),
+		Files:         make(chan FileStatistics),
+		Filtered:      make(chan []FilteredAnnouncement),
+		Errors:        make(chan error),
+
+		IPv4TooSpecific: newShardChannels(shards),
+		IPv6TooSpecific: newShardChannels(shards),
 	}
 }
 
+func newShardChannels(shards int) []chan []RouteAnnouncement {
+	channels := make([]chan []RouteAnnouncement, shards)
+	for i := range channels {
+		channels[i] = make(chan []RouteAnnouncement, shardBuffer)
+	}
+	return channels
+}
+
 func (c *Channels) Close() {
-	close(c.IPv4)
-	close(c.IPv6)
+	for _, shards := range [][]chan []RouteAnnouncement{c.IPv4, c.IPv6, c.IPv4Multicast, c.IPv6Multicast, c.IPv4TooSpecific, c.IPv6TooSpecific} {
+		for _, shard := range shards {
+			close(shard)
+		}
+	}
 	close(c.Peers)
+	close(c.SessionResets)
+	close(c.Files)
+	close(c.Filtered)
 	close(c.Errors)
 }
 
-func NewRoutes() Routes {
+// NewRoutes creates an empty routing table. If replay is set, announcements replace the previous route of the
+// receiving peer and withdrawals remove it, so that the table reflects the state after the last processed update.
+// In both modes, a session reset removes the routes which the peer announced before.
+// If delegations are set, the MOAS prefixes are annotated with their allocations and the routes of unallocated
+// prefixes and origin ASes are reported. If tooSpecific is set, the MOAS prefixes of the too specific prefixes are
+// reported separately.
+func NewRoutes(replay bool, delegations *rir.Delegations, tooSpecific bool) Routes {
 	return Routes{
-		routesIPv4: routeData{make(map[string]map[string][]Peer)},
-		routesIPv6: routeData{make(map[string]map[string][]Peer)},
+		replay:        replay,
+		tooSpecific:   tooSpecific,
+		delegations:   delegations,
+		sessionResets: make(map[Peer]int),
+		filtered:      make(map[filteredKey]map[Peer]struct{}),
 	}
 }
 
+func newRouteData(replay bool) *routeData {
+	return &routeData{
+		prefixes:     make(map[string]map[string]map[Peer][]uint32),
+		attributes:   make(map[routeKey]announcedAttributes),
+		addPathPeers: make(map[Peer]struct{}),
+		peerPrefixes: make(map[Peer]map[string]map[string]time.Time),
+		sessionDowns: make(map[Peer]time.Time),
+		replay:       replay,
+		events:       make(map[string]*moasEvent),
+
+		firstAnnounced: make(map[string]map[string]time.Time),
+	}
+}
+
+// HandleAnnouncements handles the announcements, peers and file statistics until the channels are closed. It returns
+// after all of them were handled, so the results are complete. All errors passed on the error channel are collected
+// and returned as ProcessingErrors.
 func (r *Routes) HandleAnnouncements(channels Channels) error {
-	go r.routesIPv4.handleAnnouncements(channels.IPv4)
-	go r.routesIPv6.handleAnnouncements(channels.IPv6)
-	go r.handlePeers(channels.Peers)
+	r.routesIPv4 = newRouteView(r.replay, len(channels.IPv4))
+	r.routesIPv6 = newRouteView(r.replay, len(channels.IPv6))
+	r.routesIPv4Multicast = newRouteView(r.replay, len(channels.IPv4Multicast))
+	r.routesIPv6Multicast = newRouteView(r.replay, len(channels.IPv6Multicast))
+	r.routesIPv4TooSpecific = newRouteView(r.replay, len(channels.IPv4TooSpecific))
+	r.routesIPv6TooSpecific = newRouteView(r.replay, len(channels.IPv6TooSpecific))
+
+	wg := sync.WaitGroup{}
+	r.routesIPv4.handleAnnouncements(channels.IPv4, &wg)
+	r.routesIPv6.handleAnnouncements(channels.IPv6, &wg)
+	r.routesIPv4Multicast.handleAnnouncements(channels.IPv4Multicast, &wg)
+	r.routesIPv6Multicast.handleAnnouncements(channels.IPv6Multicast, &wg)
+	r.routesIPv4TooSpecific.handleAnnouncements(channels.IPv4TooSpecific, &wg)
+	r.routesIPv6TooSpecific.handleAnnouncements(channels.IPv6TooSpecific, &wg)
+	wg.Add(4)
+	go r.handlePeers(channels.Peers, &wg)
+	go r.handleSessionResets(channels.SessionResets, &wg)
+	go r.handleFiles(channels.Files, &wg)
+	go r.handleFiltered(channels.Filtered, &wg)
 
+	// the error channel is read until it is closed, so no producer is blocked by an error
+	var errs ProcessingErrors
 	for err := range channels.Errors {
-		return errors.Wrap(err, "failed to handle route announcement")
+		errs = append(errs, err)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
 
-func (r *Routes) handlePeers(peersChan chan []Peer) {
+func (r *Routes) handlePeers(peersChan chan []Peer, wg *sync.WaitGroup) {
+	defer wg.Done()
 	for peers := range peersChan {
 		r.peers = getUniquePeers(append(r.peers, peers...))
 	}
 }
 
-func (r *routeData) handleAnnouncements(announcementChan chan RouteAnnouncement) {
-	for announcement := range announcementChan {
+func (r *Routes) handleSessionResets(sessionResetsChan chan Peer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for peer := range sessionResetsChan {
+		r.sessionResets[peer]++
+	}
+}
+
+func (r *Routes) handleFiles(filesChan chan FileStatistics, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for file := range filesChan {
+		r.files = append(r.files, file)
+	}
+}
+
+func (r *routeData) handleAnnouncements(announcementChan chan []RouteAnnouncement, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for batch := range announcementChan {
+		for _, announcement := range batch {
+			r.handleAnnouncement(announcement)
+		}
+	}
+	if !r.replay {
+		r.applySessionResets()
+	}
+}
+
+func (r *routeData) handleAnnouncement(announcement RouteAnnouncement) {
+	switch announcement.Type {
+	case Announce:
+		r.announcements++
+		if r.replay {
+			// implicit withdraw of the previous route
+			r.replaceRoutes(announcement)
+		}
 		r.addRoute(announcement)
+	case Withdraw:
+		r.withdrawals++
+		// without replay, MOAS prefixes are computed from all origins observed in the input, so a withdrawal
+		// does not remove a previously observed origin
+		if r.replay {
+			r.replaceRoutes(announcement)
+		}
+	case SessionDown:
+		if r.sessionDowns[announcement.ReceivedBy].Before(announcement.Timestamp) {
+			r.sessionDowns[announcement.ReceivedBy] = announcement.Timestamp
+		}
+		if r.replay {
+			r.removePeer(announcement.ReceivedBy, announcement.Timestamp)
+		}
+		return
+	}
+	if r.replay {
+		r.updateEvent(announcement.Prefix, announcement.Timestamp)
 	}
 }
 
 func (r *routeData) addRoute(announcement RouteAnnouncement) {
-	if res, ok := r.prefixes[announcement.Prefix]; !ok {
-		r.prefixes[announcement.Prefix] = map[string][]Peer{
-			announcement.OriginAS: {announcement.ReceivedBy},
+	origins, ok := r.prefixes[announcement.Prefix]
+	if !ok {
+		origins = make(map[string]map[Peer][]uint32)
+		r.prefixes[announcement.Prefix] = origins
+	}
+	feeders, ok := origins[announcement.OriginAS]
+	if !ok {
+		feeders = make(map[Peer][]uint32)
+		origins[announcement.OriginAS] = feeders
+	}
+	// a peer is only listed once per path, even if the route was announced multiple times
+	if !containsPathID(feeders[announcement.ReceivedBy], announcement.PathID) {
+		feeders[announcement.ReceivedBy] = append(feeders[announcement.ReceivedBy], announcement.PathID)
+	}
+	if announcement.AddPath {
+		r.addPathPeers[announcement.ReceivedBy] = struct{}{}
+	}
+
+	if !r.replay {
+		firstAnnounced, ok := r.firstAnnounced[announcement.Prefix]
+		if !ok {
+			firstAnnounced = make(map[string]time.Time)
+			r.firstAnnounced[announcement.Prefix] = firstAnnounced
+		}
+		if first, ok := firstAnnounced[announcement.OriginAS]; !ok || announcement.Timestamp.Before(first) {
+			firstAnnounced[announcement.OriginAS] = announcement.Timestamp
+		}
+	}
+
+	prefixes, ok := r.peerPrefixes[announcement.ReceivedBy]
+	if !ok {
+		prefixes = make(map[string]map[string]time.Time)
+		r.peerPrefixes[announcement.ReceivedBy] = prefixes
+	}
+	announced, ok := prefixes[announcement.Prefix]
+	if !ok {
+		announced = make(map[string]time.Time)
+		prefixes[announcement.Prefix] = announced
+	}
+	if last, ok := announced[announcement.OriginAS]; !ok || last.Before(announcement.Timestamp) {
+		announced[announcement.OriginAS] = announcement.Timestamp
+	}
+
+	// the attributes of the latest announcement of the path are kept, the announcements of different files are not
+	// handled in time order without replay
+	if announcement.Attributes != nil {
+		key := routeKey{
+			prefix: announcement.Prefix,
+			origin: announcement.OriginAS,
+			peer:   announcement.ReceivedBy,
+			pathID: announcement.PathID,
+		}
+		if last, ok := r.attributes[key]; !ok || !announcement.Timestamp.Before(last.timestamp) {
+			r.attributes[key] = announcedAttributes{
+				attributes: announcement.Attributes,
+				timestamp:  announcement.Timestamp,
+			}
+		}
+	}
+}
+
+// removeRoute removes the routes with the path ID received by the peer.
+func (r *routeData) removeRoute(prefix string, peer Peer, pathID uint32) {
+	for origin := range r.prefixes[prefix] {
+		r.removeOriginRoute(prefix, origin, peer, pathID)
+	}
+}
+
+// removeOriginRoute removes the route to the origin with the path ID received by the peer.
+func (r *routeData) removeOriginRoute(prefix, origin string, peer Peer, pathID uint32) {
+	origins := r.prefixes[prefix]
+	feeders := origins[origin]
+	pathIDs, ok := feeders[peer]
+	if !ok {
+		return
+	}
+	delete(r.attributes, routeKey{prefix: prefix, origin: origin, peer: peer, pathID: pathID})
+
+	var remaining []uint32
+	for _, id := range pathIDs {
+		if id != pathID {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(feeders, peer)
+		delete(r.peerPrefixes[peer][prefix], origin)
+		if len(r.peerPrefixes[peer][prefix]) == 0 {
+			delete(r.peerPrefixes[peer], prefix)
 		}
 	} else {
-		if feeders, ok := res[announcement.OriginAS]; !ok {
-			r.prefixes[announcement.Prefix][announcement.OriginAS] = []Peer{announcement.ReceivedBy}
-		} else {
-			r.prefixes[announcement.Prefix][announcement.OriginAS] = append(feeders, announcement.ReceivedBy)
+		feeders[peer] = remaining
+	}
+
+	if len(feeders) == 0 {
+		delete(origins, origin)
+	}
+	if len(origins) == 0 {
+		delete(r.prefixes, prefix)
+	}
+}
+
+// replaceRoutes removes the routes of the peer which are replaced by the announcement or withdrawal. An ADD-PATH
+// update only replaces the route with the same path ID. A peer without ADD-PATH has only one route to the prefix, so
+// all routes of the peer are replaced, including the ADD-PATH routes of a table dump.
+func (r *routeData) replaceRoutes(announcement RouteAnnouncement) {
+	if announcement.AddPath {
+		r.removeRoute(announcement.Prefix, announcement.ReceivedBy, announcement.PathID)
+		return
+	}
+	r.removePeerRoutes(announcement.Prefix, announcement.ReceivedBy)
+}
+
+// removePeerRoutes removes all routes to the prefix received by the peer.
+func (r *routeData) removePeerRoutes(prefix string, peer Peer) {
+	for origin := range r.prefixes[prefix] {
+		r.removePeerOriginRoutes(prefix, origin, peer)
+	}
+}
+
+// removePeerOriginRoutes removes all routes to the prefix with the origin received by the peer.
+func (r *routeData) removePeerOriginRoutes(prefix, origin string, peer Peer) {
+	for _, pathID := range append([]uint32{}, r.prefixes[prefix][origin][peer]...) {
+		r.removeOriginRoute(prefix, origin, peer, pathID)
+	}
+}
+
+// removePeer removes all routes received by the peer before its session went down at the given time. It is only used
+// in replay mode, in which the announcements are handled in time order.
+func (r *routeData) removePeer(peer Peer, timestamp time.Time) {
+	for prefix := range r.peerPrefixes[peer] {
+		r.removePeerRoutes(prefix, peer)
+		r.updateEvent(prefix, timestamp)
+	}
+}
+
+// applySessionResets removes the routes which the peers announced last before their last session reset. Without
+// replay, the files are not processed in time order, so the session resets are only applied after all announcements
+// were handled, which makes the result independent of the order of the files.
+func (r *routeData) applySessionResets() {
+	for peer, sessionDown := range r.sessionDowns {
+		for prefix, origins := range r.peerPrefixes[peer] {
+			for origin, announced := range origins {
+				if announced.Before(sessionDown) {
+					r.removePeerOriginRoutes(prefix, origin, peer)
+				}
+			}
 		}
 	}
 }
 
 func (r *Routes) PrintMOASPrefixes(directory string) error {
 	moasIPv4 := r.routesIPv4.getMOASPrefixes()
-	err := printJSON(moasIPv4, directory, "moasIPv4.json")
+	moasIPv6 := r.routesIPv6.getMOASPrefixes()
+	moasIPv4Multicast := r.routesIPv4Multicast.getMOASPrefixes()
+	moasIPv6Multicast := r.routesIPv6Multicast.getMOASPrefixes()
+	moasIPv4TooSpecific := r.routesIPv4TooSpecific.getMOASPrefixes()
+	moasIPv6TooSpecific := r.routesIPv6TooSpecific.getMOASPrefixes()
+	var unallocatedRoutes int
+	if r.delegations != nil {
+		for _, moas := range [][]MOASPrefix{moasIPv4, moasIPv6, moasIPv4Multicast, moasIPv6Multicast, moasIPv4TooSpecific, moasIPv6TooSpecific} {
+			annotateAllocations(moas, r.delegations)
+		}
+
+		unallocated := append(r.routesIPv4.getUnallocatedRoutes(r.delegations), r.routesIPv6.getUnallocatedRoutes(r.delegations)...)
+		sortUnallocatedRoutes(unallocated)
+		err := printJSON(unallocated, directory, "unallocated.json")
+		if err != nil {
+			return errors.Wrap(err, "failed to print unallocated routes file")
+		}
+		unallocatedRoutes += len(unallocated)
+
+		unallocated = append(r.routesIPv4Multicast.getUnallocatedRoutes(r.delegations), r.routesIPv6Multicast.getUnallocatedRoutes(r.delegations)...)
+		sortUnallocatedRoutes(unallocated)
+		err = printJSON(unallocated, directory, "unallocatedMulticast.json")
+		if err != nil {
+			return errors.Wrap(err, "failed to print multicast unallocated routes file")
+		}
+		unallocatedRoutes += len(unallocated)
+
+		if r.tooSpecific {
+			unallocated = append(r.routesIPv4TooSpecific.getUnallocatedRoutes(r.delegations), r.routesIPv6TooSpecific.getUnallocatedRoutes(r.delegations)...)
+			sortUnallocatedRoutes(unallocated)
+			err = printJSON(unallocated, directory, "unallocatedTooSpecific.json")
+			if err != nil {
+				return errors.Wrap(err, "failed to print too specific unallocated routes file")
+			}
+			unallocatedRoutes += len(unallocated)
+		}
+	}
+
+	err := printJSON(r.getFilteredRoutes(), directory, "filtered.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to print filtered routes file")
+	}
+
+	err = printJSON(moasIPv4, directory, "moasIPv4.json")
 	if err != nil {
 		return errors.Wrap(err, "failed to print IPv4 MOAS file")
 	}
 
-	moasIPv6 := r.routesIPv6.getMOASPrefixes()
 	err = printJSON(moasIPv6, directory, "moasIPv6.json")
 	if err != nil {
 		return errors.Wrap(err, "failed to print IPv6 MOAS file")
 	}
 
-	err = printJSON(r.getStatistics(moasIPv4, moasIPv6), directory, "statistics.json")
+	err = printJSON(moasIPv4Multicast, directory, "moasIPv4Multicast.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to print IPv4 multicast MOAS file")
+	}
+
+	err = printJSON(moasIPv6Multicast, directory, "moasIPv6Multicast.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to print IPv6 multicast MOAS file")
+	}
+
+	if r.tooSpecific {
+		err = printJSON(moasIPv4TooSpecific, directory, "moasIPv4TooSpecific.json")
+		if err != nil {
+			return errors.Wrap(err, "failed to print IPv4 too specific MOAS file")
+		}
+
+		err = printJSON(moasIPv6TooSpecific, directory, "moasIPv6TooSpecific.json")
+		if err != nil {
+			return errors.Wrap(err, "failed to print IPv6 too specific MOAS file")
+		}
+	}
+
+	events := append(r.routesIPv4.getMOASEvents(), r.routesIPv6.getMOASEvents()...)
+	sortMOASEvents(events)
+	err = printJSON(events, directory, "moasEvents.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to print MOAS events file")
+	}
+
+	events = append(r.routesIPv4Multicast.getMOASEvents(), r.routesIPv6Multicast.getMOASEvents()...)
+	sortMOASEvents(events)
+	err = printJSON(events, directory, "moasEventsMulticast.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to print multicast MOAS events file")
+	}
+
+	if r.tooSpecific {
+		events = append(r.routesIPv4TooSpecific.getMOASEvents(), r.routesIPv6TooSpecific.getMOASEvents()...)
+		sortMOASEvents(events)
+		err = printJSON(events, directory, "moasEventsTooSpecific.json")
+		if err != nil {
+			return errors.Wrap(err, "failed to print too specific MOAS events file")
+		}
+	}
+
+	statistics := r.getStatistics(moasIPv4, moasIPv6, moasIPv4Multicast, moasIPv6Multicast)
+	statistics.UnallocatedRoutes = unallocatedRoutes
+	if r.tooSpecific {
+		statistics.IPv4TooSpecificPrefixes = r.routesIPv4TooSpecific.prefixes()
+		statistics.IPv6TooSpecificPrefixes = r.routesIPv6TooSpecific.prefixes()
+		statistics.IPv4TooSpecificMOASPrefixes = len(moasIPv4TooSpecific)
+		statistics.IPv6TooSpecificMOASPrefixes = len(moasIPv6TooSpecific)
+	}
+	err = printJSON(statistics, directory, "statistics.json")
 	if err != nil {
 		return errors.Wrap(err, "failed to print statistics file")
 	}
@@ -150,12 +810,50 @@ func (r *routeData) getMOASPrefixes() []MOASPrefix {
 			moasPrefix := MOASPrefix{
 				Prefix: prefix,
 			}
-			for origin, peers := range origins {
-				moasPrefix.Origin = append(moasPrefix.Origin, MOASPrefixOrigin{
+			addPathOrigins := make(map[Peer]int)
+			for origin, feeders := range origins {
+				moasPrefixOrigin := MOASPrefixOrigin{
 					AS:         origin,
-					Visibility: getUniquePeers(peers),
-				})
+					Visibility: getSortedPeers(feeders),
+				}
+				for _, peer := range moasPrefixOrigin.Visibility {
+					pathIDs := append([]uint32{}, feeders[peer]...)
+					sort.Slice(pathIDs, func(i, j int) bool {
+						return pathIDs[i] < pathIDs[j]
+					})
+					_, addPath := r.addPathPeers[peer]
+					if addPath {
+						addPathOrigins[peer]++
+						moasPrefixOrigin.PathIDs = append(moasPrefixOrigin.PathIDs, PeerPathIDs{
+							Peer:    peer,
+							PathIDs: pathIDs,
+						})
+					}
+
+					for _, pathID := range pathIDs {
+						attributes, ok := r.attributes[routeKey{prefix: prefix, origin: origin, peer: peer, pathID: pathID}]
+						if !ok {
+							continue
+						}
+						route := Route{
+							Peer:            peer,
+							RouteAttributes: *attributes.attributes,
+						}
+						if addPath {
+							pathID := pathID
+							route.PathID = &pathID
+						}
+						moasPrefixOrigin.Routes = append(moasPrefixOrigin.Routes, route)
+					}
+				}
+				moasPrefix.Origin = append(moasPrefix.Origin, moasPrefixOrigin)
 			}
+			for peer, count := range addPathOrigins {
+				if count > 1 {
+					moasPrefix.AddPathPeers = append(moasPrefix.AddPathPeers, peer)
+				}
+			}
+			sortPeers(moasPrefix.AddPathPeers)
 			moas = append(moas, moasPrefix)
 		}
 	}
@@ -163,81 +861,165 @@ func (r *routeData) getMOASPrefixes() []MOASPrefix {
 	return moas
 }
 
-func (r *Routes) getStatistics(moasIPv4, moasIPv6 []MOASPrefix) Statistics {
+func (r *Routes) getStatistics(moasIPv4, moasIPv6, moasIPv4Multicast, moasIPv6Multicast []MOASPrefix) Statistics {
 	statistics := Statistics{
-		IPv4Prefixes:     len(r.routesIPv4.prefixes),
-		IPv6Prefixes:     len(r.routesIPv6.prefixes),
+		IPv4Prefixes:     r.routesIPv4.prefixes(),
+		IPv6Prefixes:     r.routesIPv6.prefixes(),
 		IPv4MOASPrefixes: len(moasIPv4),
 		IPv6MOASPrefixes: len(moasIPv6),
-	}
 
-	moasIPv4Lookup := make(map[string]struct{})
-	for _, prefix := range moasIPv4 {
-		moasIPv4Lookup[prefix.Prefix] = struct{}{}
-	}
+		IPv4Announcements: r.routesIPv4.announcements(),
+		IPv6Announcements: r.routesIPv6.announcements(),
+		IPv4Withdrawals:   r.routesIPv4.withdrawals(),
+		IPv6Withdrawals:   r.routesIPv6.withdrawals(),
+
+		IPv4MulticastPrefixes:      r.routesIPv4Multicast.prefixes(),
+		IPv6MulticastPrefixes:      r.routesIPv6Multicast.prefixes(),
+		IPv4MulticastMOASPrefixes:  len(moasIPv4Multicast),
+		IPv6MulticastMOASPrefixes:  len(moasIPv6Multicast),
+		IPv4MulticastAnnouncements: r.routesIPv4Multicast.announcements(),
+		IPv6MulticastAnnouncements: r.routesIPv6Multicast.announcements(),
+		IPv4MulticastWithdrawals:   r.routesIPv4Multicast.withdrawals(),
+		IPv6MulticastWithdrawals:   r.routesIPv6Multicast.withdrawals(),
 
-	moasIPv6Lookup := make(map[string]struct{})
-	for _, prefix := range moasIPv6 {
-		moasIPv6Lookup[prefix.Prefix] = struct{}{}
+		Filtered: make(map[string]int),
+		Files:    r.files,
 	}
 
-	peerStatistics := make(map[Peer]PeerStatistics)
+	var firstDump, lastDump *time.Time
+	for _, file := range r.files {
+		if file.Format == FileFormatUnknown {
+			statistics.UnknownFormatFiles++
+		}
+		if file.Error != "" {
+			statistics.FailedFiles++
+		}
+		if file.Truncated {
+			statistics.TruncatedFiles++
+		}
+		if file.TooFarFromTarget {
+			statistics.DistantDumpFiles++
+		}
+		statistics.ReconstructedASPaths += file.ReconstructedASPaths
+		statistics.ASPaths.add(file.ASPaths)
+		for reason, count := range file.Filtered {
+			statistics.Filtered[reason] += count
+		}
 
-	for prefix, origins := range r.routesIPv4.prefixes {
-		var prefixPeers []Peer
-		var prefixIsMOAS bool
-		if _, ok := moasIPv4Lookup[prefix]; ok {
-			prefixIsMOAS = true
+		if file.ObservationStart != nil && (statistics.ObservationStart == nil || file.ObservationStart.Before(*statistics.ObservationStart)) {
+			statistics.ObservationStart = file.ObservationStart
 		}
-		for _, receivedByPeers := range origins {
-			prefixPeers = append(prefixPeers, receivedByPeers...)
+		if file.ObservationEnd != nil && (statistics.ObservationEnd == nil || file.ObservationEnd.After(*statistics.ObservationEnd)) {
+			statistics.ObservationEnd = file.ObservationEnd
 		}
-		for _, prefixPeer := range getUniquePeers(prefixPeers) {
-			peerStatistic, ok := peerStatistics[prefixPeer]
-			if !ok {
-				peerStatistic.Peer = prefixPeer
+		if file.RIBRecords > 0 && file.ObservationStart != nil {
+			if firstDump == nil || file.ObservationStart.Before(*firstDump) {
+				firstDump = file.ObservationStart
+			}
+			if lastDump == nil || file.ObservationStart.After(*lastDump) {
+				lastDump = file.ObservationStart
 			}
-			peerStatistic.IPv4Prefixes += 1
-			if prefixIsMOAS {
-				peerStatistic.IPv4MOASPrefixes += 1
+		}
+	}
+	if firstDump != nil {
+		statistics.DumpSkew = int64(lastDump.Sub(*firstDump).Seconds())
+	}
+
+	peerStatistics := make(map[Peer]*PeerStatistics)
+	collectorStatistics := make(map[string]*CollectorStatistics)
+	for _, peer := range r.peers {
+		peerStatistics[peer] = &PeerStatistics{
+			Peer:          peer,
+			SessionResets: r.sessionResets[peer],
+		}
+
+		collectorStatistic, ok := collectorStatistics[peer.Collector]
+		if !ok {
+			collectorStatistic = &CollectorStatistics{
+				Collector: peer.Collector,
 			}
-			peerStatistics[prefixPeer] = peerStatistic
+			collectorStatistics[peer.Collector] = collectorStatistic
+		}
+		collectorStatistic.Peers++
+		collectorStatistic.SessionResets += r.sessionResets[peer]
+	}
+
+	r.routesIPv4.countPrefixes(peerStatistics, collectorStatistics, moasIPv4, func(prefixStatistic *PrefixStatistics, isMOAS bool) {
+		prefixStatistic.IPv4Prefixes += 1
+		if isMOAS {
+			prefixStatistic.IPv4MOASPrefixes += 1
 		}
+	})
+	r.routesIPv6.countPrefixes(peerStatistics, collectorStatistics, moasIPv6, func(prefixStatistic *PrefixStatistics, isMOAS bool) {
+		prefixStatistic.IPv6Prefixes += 1
+		if isMOAS {
+			prefixStatistic.IPv6MOASPrefixes += 1
+		}
+	})
+	r.routesIPv4Multicast.countPrefixes(peerStatistics, collectorStatistics, moasIPv4Multicast, func(prefixStatistic *PrefixStatistics, isMOAS bool) {
+		prefixStatistic.IPv4MulticastPrefixes += 1
+		if isMOAS {
+			prefixStatistic.IPv4MulticastMOASPrefixes += 1
+		}
+	})
+	r.routesIPv6Multicast.countPrefixes(peerStatistics, collectorStatistics, moasIPv6Multicast, func(prefixStatistic *PrefixStatistics, isMOAS bool) {
+		prefixStatistic.IPv6MulticastPrefixes += 1
+		if isMOAS {
+			prefixStatistic.IPv6MulticastMOASPrefixes += 1
+		}
+	})
+
+	for _, peer := range r.peers {
+		statistics.Peers = append(statistics.Peers, *peerStatistics[peer])
+	}
+	for _, collectorStatistic := range collectorStatistics {
+		statistics.Collectors = append(statistics.Collectors, *collectorStatistic)
 	}
+	sort.Slice(statistics.Collectors, func(i, j int) bool {
+		return statistics.Collectors[i].Collector < statistics.Collectors[j].Collector
+	})
+
+	return statistics
+}
 
-	for prefix, origins := range r.routesIPv6.prefixes {
-		var prefixPeers []Peer
+// countPrefixes calls count once per prefix for every peer and every collector which received a route for the prefix.
+func (r *routeData) countPrefixes(peerStatistics map[Peer]*PeerStatistics, collectorStatistics map[string]*CollectorStatistics, moasLookup map[string]struct{}, count func(prefixStatistic *PrefixStatistics, isMOAS bool)) {
+	for prefix, origins := range r.prefixes {
+		prefixPeers := make(map[Peer]struct{})
 		var prefixIsMOAS bool
-		if _, ok := moasIPv6Lookup[prefix]; ok {
+		if _, ok := moasLookup[prefix]; ok {
 			prefixIsMOAS = true
 		}
 		for _, receivedByPeers := range origins {
-			prefixPeers = append(prefixPeers, receivedByPeers...)
+			for peer := range receivedByPeers {
+				prefixPeers[peer] = struct{}{}
+			}
 		}
-		for _, prefixPeer := range getUniquePeers(prefixPeers) {
+
+		prefixCollectors := make(map[string]struct{})
+		for prefixPeer := range prefixPeers {
 			peerStatistic, ok := peerStatistics[prefixPeer]
 			if !ok {
-				peerStatistic.Peer = prefixPeer
-			}
-			peerStatistic.IPv6Prefixes += 1
-			if prefixIsMOAS {
-				peerStatistic.IPv6MOASPrefixes += 1
+				peerStatistic = &PeerStatistics{
+					Peer: prefixPeer,
+				}
+				peerStatistics[prefixPeer] = peerStatistic
 			}
-			peerStatistics[prefixPeer] = peerStatistic
+			count(&peerStatistic.PrefixStatistics, prefixIsMOAS)
+			prefixCollectors[prefixPeer.Collector] = struct{}{}
 		}
-	}
 
-	for _, peer := range r.peers {
-		if peerStatistic, ok := peerStatistics[peer]; ok {
-			statistics.Peers = append(statistics.Peers, peerStatistic)
-		} else {
-			statistics.Peers = append(statistics.Peers, PeerStatistics{
-				Peer: peer,
-			})
+		for collector := range prefixCollectors {
+			collectorStatistic, ok := collectorStatistics[collector]
+			if !ok {
+				collectorStatistic = &CollectorStatistics{
+					Collector: collector,
+				}
+				collectorStatistics[collector] = collectorStatistic
+			}
+			count(&collectorStatistic.PrefixStatistics, prefixIsMOAS)
 		}
 	}
-
-	return statistics
 }
 
 func getUniquePeers(peers []Peer) []Peer {
@@ -252,6 +1034,67 @@ func getUniquePeers(peers []Peer) []Peer {
 	return uniquePeers
 }
 
+// getSortedPeers returns the peers of the map sorted by AS, IP and collector.
+func getSortedPeers(peers map[Peer][]uint32) []Peer {
+	sorted := make([]Peer, 0, len(peers))
+	for peer := range peers {
+		sorted = append(sorted, peer)
+	}
+	sortPeers(sorted)
+	return sorted
+}
+
+func sortPeers(peers []Peer) {
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].AS != peers[j].AS {
+			return peers[i].AS < peers[j].AS
+		}
+		if peers[i].IP != peers[j].IP {
+			return peers[i].IP < peers[j].IP
+		}
+		return peers[i].Collector < peers[j].Collector
+	})
+}
+
+func containsPathID(pathIDs []uint32, pathID uint32) bool {
+	for _, id := range pathIDs {
+		if id == pathID {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotIndex describes the results of a batch run.
+type SnapshotIndex struct {
+	Snapshots []SnapshotIndexEntry `json:"snapshots"`
+	// Skipped contains the input files which do not belong to any snapshot, as their time could not be determined.
+	Skipped []SkippedFile `json:"skipped"`
+}
+
+// SkippedFile is an input file which was not processed.
+type SkippedFile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// SnapshotIndexEntry describes the results of a snapshot in batch mode.
+type SnapshotIndexEntry struct {
+	Time time.Time `json:"time"`
+	// Directory is the subdirectory of the output directory which contains the results of the snapshot.
+	Directory string   `json:"directory"`
+	Files     []string `json:"files"`
+}
+
+// PrintSnapshotIndex writes the index of all snapshots of a batch run.
+func PrintSnapshotIndex(index SnapshotIndex, directory string) error {
+	err := printJSON(index, directory, "index.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to print snapshot index file")
+	}
+	return nil
+}
+
 func printJSON(data interface{}, directory, filename string) error {
 	d, err := json.Marshal(data)
 	if err != nil {
@@ -0,0 +1,302 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouteData_AddPath(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+
+	r := newRouteData(true)
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "198.51.100.0/24", OriginAS: "1103", ReceivedBy: peer, AddPath: true, PathID: 1})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "198.51.100.0/24", OriginAS: "1103", ReceivedBy: peer, AddPath: true, PathID: 2})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "198.51.100.0/24", OriginAS: "13335", ReceivedBy: peer, AddPath: true, PathID: 3})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "198.51.100.0/24", OriginAS: "13335", ReceivedBy: other})
+
+	moas := r.getMOASPrefixes()
+	if assert.Len(t, moas, 1) {
+		assert.Equal(t, []Peer{peer}, moas[0].AddPathPeers)
+		for _, origin := range moas[0].Origin {
+			switch origin.AS {
+			case "1103":
+				assert.Equal(t, []Peer{peer}, origin.Visibility)
+				assert.Equal(t, []PeerPathIDs{{Peer: peer, PathIDs: []uint32{1, 2}}}, origin.PathIDs)
+			case "13335":
+				assert.Equal(t, []Peer{other, peer}, origin.Visibility)
+				assert.Equal(t, []PeerPathIDs{{Peer: peer, PathIDs: []uint32{3}}}, origin.PathIDs)
+			}
+		}
+	}
+
+	// the announcement replaces the route with the same path ID only
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "198.51.100.0/24", OriginAS: "1103", ReceivedBy: peer, AddPath: true, PathID: 3})
+	assert.Equal(t, map[Peer][]uint32{peer: {1, 2, 3}}, r.prefixes["198.51.100.0/24"]["1103"])
+	assert.Equal(t, map[Peer][]uint32{other: {0}}, r.prefixes["198.51.100.0/24"]["13335"])
+
+	r.handleAnnouncement(RouteAnnouncement{Type: Withdraw, Prefix: "198.51.100.0/24", ReceivedBy: peer, AddPath: true, PathID: 2})
+	assert.Equal(t, map[Peer][]uint32{peer: {1, 3}}, r.prefixes["198.51.100.0/24"]["1103"])
+}
+
+func TestRouteData_ReplaceRoutes(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+
+	r := newRouteData(true)
+	// the ADD-PATH routes of a table dump
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer, AddPath: true, PathID: 1})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: peer, AddPath: true, PathID: 2})
+	assert.Len(t, r.events, 1)
+
+	// an update without ADD-PATH replaces all routes of the peer
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer})
+	assert.Equal(t, map[string]map[string]map[Peer][]uint32{
+		"193.0.0.0/21": {"1103": {peer: {0}}},
+	}, r.prefixes)
+	assert.Empty(t, r.events)
+
+	// an ADD-PATH withdrawal only removes the route with the same path ID
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer, AddPath: true, PathID: 1})
+	r.handleAnnouncement(RouteAnnouncement{Type: Withdraw, Prefix: "193.0.0.0/21", ReceivedBy: peer, AddPath: true, PathID: 1})
+	assert.Equal(t, map[string]map[string]map[Peer][]uint32{
+		"193.0.0.0/21": {"1103": {peer: {0}}},
+	}, r.prefixes)
+
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: peer, AddPath: true, PathID: 2})
+	r.handleAnnouncement(RouteAnnouncement{Type: Withdraw, Prefix: "193.0.0.0/21", ReceivedBy: peer})
+	assert.Empty(t, r.prefixes)
+	assert.Empty(t, r.peerPrefixes[peer])
+}
+
+func TestRouteData_LatestAttributes(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+	start := time.Date(2022, 2, 5, 8, 0, 0, 0, time.UTC)
+
+	older := &RouteAttributes{ASPath: "3333 174 1103", NextHop: "192.0.2.1", Origin: "IGP"}
+	newer := &RouteAttributes{ASPath: "3333 1103", NextHop: "192.0.2.1", Origin: "IGP"}
+
+	// without replay, a later file can contain older announcements, the attributes of the latest one are kept
+	r := newRouteData(false)
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: other, Timestamp: start})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer, Timestamp: start.Add(time.Minute), Attributes: newer})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer, Timestamp: start, Attributes: older})
+
+	assert.Equal(t, []Route{{Peer: peer, RouteAttributes: *newer}}, getOriginRoutes(r, "1103"))
+
+	// announcements with the same timestamp replace the attributes in the order they were handled
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer, Timestamp: start.Add(time.Minute), Attributes: older})
+	assert.Equal(t, []Route{{Peer: peer, RouteAttributes: *older}}, getOriginRoutes(r, "1103"))
+}
+
+// getOriginRoutes returns the routes of the origin of the MOAS prefixes.
+func getOriginRoutes(r *routeData, origin string) []Route {
+	var routes []Route
+	for _, moasPrefix := range r.getMOASPrefixes() {
+		for _, moasPrefixOrigin := range moasPrefix.Origin {
+			if moasPrefixOrigin.AS == origin {
+				routes = append(routes, moasPrefixOrigin.Routes...)
+			}
+		}
+	}
+	return routes
+}
+
+func TestRouteData_MOASEventVisibility(t *testing.T) {
+	// the same router peers with two collectors
+	rrc00 := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	rrc01 := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc01"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+
+	r := newRouteData(true)
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: other})
+	for _, peer := range []Peer{rrc01, rrc00} {
+		r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer})
+	}
+
+	events := r.getMOASEvents()
+	if assert.Len(t, events, 1) && assert.Len(t, events[0].Origin, 2) {
+		assert.Equal(t, []Peer{rrc00, rrc01}, events[0].Origin[0].Visibility)
+	}
+}
+
+func TestRouteData_SessionDown(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+	dumpTime := time.Date(2022, 2, 5, 8, 0, 0, 0, time.UTC)
+
+	// without replay, the announcements of the table dump and the update files are not handled in time order, the
+	// session resets are applied afterwards, so the result does not depend on the order
+	announcements := []RouteAnnouncement{
+		{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: peer, Timestamp: dumpTime},
+		{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: other, Timestamp: dumpTime},
+		{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "3333", ReceivedBy: peer, Timestamp: dumpTime.Add(10 * time.Minute)},
+		{Type: SessionDown, ReceivedBy: peer, Timestamp: dumpTime.Add(5 * time.Minute)},
+		{Type: Announce, Prefix: "193.0.10.0/23", OriginAS: "3333", ReceivedBy: peer, Timestamp: dumpTime.Add(time.Minute)},
+		{Type: SessionDown, ReceivedBy: peer, Timestamp: dumpTime.Add(2 * time.Minute)},
+		{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "3333", ReceivedBy: peer, Timestamp: dumpTime},
+		// the route to the other origin was replaced before the session reset
+		{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "1103", ReceivedBy: peer, Timestamp: dumpTime},
+	}
+	for i := 0; i < 2*len(announcements); i++ {
+		// every rotation of the announcements, forwards and backwards
+		r := newRouteData(false)
+		for j := range announcements {
+			index := (i + j) % len(announcements)
+			if i >= len(announcements) {
+				index = len(announcements) - 1 - index
+			}
+			r.handleAnnouncement(announcements[index])
+		}
+		r.applySessionResets()
+
+		assert.Equal(t, map[string]map[string]map[Peer][]uint32{
+			"193.0.0.0/21": {"3333": {other: {0}}},
+			"193.0.8.0/23": {"3333": {peer: {0}}},
+		}, r.prefixes)
+		assert.Equal(t, map[string]map[string]time.Time{"193.0.8.0/23": {"3333": dumpTime.Add(10 * time.Minute)}}, r.peerPrefixes[peer])
+	}
+
+	// in replay mode, all routes are removed
+	r := newRouteData(true)
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: peer, Timestamp: dumpTime, AddPath: true, PathID: 1})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "1103", ReceivedBy: peer, Timestamp: dumpTime, AddPath: true, PathID: 2})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: other, Timestamp: dumpTime})
+	r.handleAnnouncement(RouteAnnouncement{Type: SessionDown, ReceivedBy: peer, Timestamp: dumpTime.Add(time.Minute)})
+	r.handleAnnouncement(RouteAnnouncement{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "3333", ReceivedBy: peer, Timestamp: dumpTime.Add(time.Minute)})
+
+	assert.Equal(t, map[string]map[string]map[Peer][]uint32{
+		"193.0.0.0/21": {"3333": {other: {0}}},
+		"193.0.8.0/23": {"3333": {peer: {0}}},
+	}, r.prefixes)
+	assert.Equal(t, map[string]map[string]time.Time{"193.0.8.0/23": {"3333": dumpTime.Add(time.Minute)}}, r.peerPrefixes[peer])
+}
+
+func TestRoutes_FilteredRoutes(t *testing.T) {
+	peer := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+
+	r := NewRoutes(false, nil, false)
+	filteredChan := make(chan []FilteredAnnouncement, 2)
+	filteredChan <- []FilteredAnnouncement{
+		{Reason: FilterReasonReservedPrefix, Prefix: "10.0.0.0/8", OriginAS: "1103", ReceivedBy: peer},
+		{Reason: FilterReasonReservedASN, Prefix: "193.0.0.0/21", OriginAS: "64512", ReceivedBy: peer},
+	}
+	filteredChan <- []FilteredAnnouncement{
+		{Reason: FilterReasonReservedPrefix, Prefix: "10.0.0.0/8", OriginAS: "1103", ReceivedBy: other},
+		{Reason: FilterReasonReservedPrefix, Prefix: "10.0.0.0/8", OriginAS: "1103", ReceivedBy: other, Multicast: true},
+	}
+	close(filteredChan)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	r.handleFiltered(filteredChan, &wg)
+
+	assert.Equal(t, map[string][]FilteredRoute{
+		FilterReasonReservedPrefix: {
+			{Prefix: "10.0.0.0/8", OriginAS: "1103", Visibility: []Peer{other, peer}},
+			{Prefix: "10.0.0.0/8", OriginAS: "1103", Multicast: true, Visibility: []Peer{other}},
+		},
+		FilterReasonReservedASN: {
+			{Prefix: "193.0.0.0/21", OriginAS: "64512", Visibility: []Peer{peer}},
+		},
+	}, r.getFilteredRoutes())
+}
+
+func TestRoutes_HandleAnnouncements(t *testing.T) {
+	rrc00 := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc00"}
+	other := Peer{AS: "174", IP: "192.0.2.2", Collector: "rrc00"}
+	rrc01 := Peer{AS: "3333", IP: "192.0.2.1", Collector: "rrc01"}
+	dumpTime := time.Date(2022, 2, 5, 8, 0, 0, 0, time.UTC)
+	laterDumpTime := dumpTime.Add(5 * time.Minute)
+
+	channels := NewChannels(2)
+	go func() {
+		channels.Peers <- []Peer{rrc00, other}
+		channels.Peers <- []Peer{rrc01, rrc00}
+		channels.SessionResets <- other
+
+		ipv4 := NewBatcher(channels.IPv4)
+		ipv4.Add(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: rrc00, Timestamp: dumpTime})
+		ipv4.Add(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "3333", ReceivedBy: rrc01, Timestamp: laterDumpTime})
+		ipv4.Add(RouteAnnouncement{Type: Announce, Prefix: "193.0.0.0/21", OriginAS: "13335", ReceivedBy: other, Timestamp: dumpTime})
+		ipv4.Add(RouteAnnouncement{Type: Announce, Prefix: "193.0.8.0/23", OriginAS: "3333", ReceivedBy: rrc00, Timestamp: dumpTime})
+		ipv4.Add(RouteAnnouncement{Type: Withdraw, Prefix: "193.0.8.0/23", ReceivedBy: other, Timestamp: dumpTime})
+		ipv4.Flush()
+		ipv6 := NewBatcher(channels.IPv6)
+		ipv6.Add(RouteAnnouncement{Type: Announce, Prefix: "2001:67c:2e8::/48", OriginAS: "3333", ReceivedBy: rrc00, Timestamp: dumpTime})
+		ipv6.Flush()
+
+		channels.Files <- FileStatistics{Name: "rrc00/bview.20220205.0800.gz", RIBRecords: 3, ObservationStart: &dumpTime,
+			Filtered: map[string]int{FilterReasonReservedASN: 2}}
+		channels.Files <- FileStatistics{Name: "rrc01/bview.20220205.0805.gz", RIBRecords: 1, ObservationStart: &laterDumpTime,
+			Filtered: map[string]int{FilterReasonReservedASN: 1}, Error: "unexpected EOF"}
+		channels.Files <- FileStatistics{Name: "rrc01/README", Format: FileFormatUnknown}
+
+		// all errors are collected, the producers are not blocked after the first one
+		channels.Errors <- errors.New("first error")
+		channels.Errors <- errors.New("second error")
+		channels.Close()
+	}()
+
+	r := NewRoutes(false, nil, false)
+	err := r.HandleAnnouncements(channels)
+	assert.EqualError(t, err, "2 errors occurred: first error; second error")
+
+	directory := t.TempDir()
+	require.NoError(t, r.PrintMOASPrefixes(directory))
+
+	var moas []MOASPrefix
+	readJSON(t, directory, "moasIPv4.json", &moas)
+	if assert.Len(t, moas, 1) {
+		assert.Equal(t, "193.0.0.0/21", moas[0].Prefix)
+		assert.Len(t, moas[0].Origin, 2)
+	}
+	readJSON(t, directory, "moasIPv6.json", &moas)
+	assert.Empty(t, moas)
+
+	var events []MOASEvent
+	readJSON(t, directory, "moasEvents.json", &events)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "193.0.0.0/21", events[0].Prefix)
+		assert.True(t, dumpTime.Equal(events[0].Start))
+	}
+
+	var statistics Statistics
+	readJSON(t, directory, "statistics.json", &statistics)
+	assert.Equal(t, 2, statistics.IPv4Prefixes)
+	assert.Equal(t, 1, statistics.IPv6Prefixes)
+	assert.Equal(t, 1, statistics.IPv4MOASPrefixes)
+	assert.Equal(t, 0, statistics.IPv6MOASPrefixes)
+	assert.Equal(t, 4, statistics.IPv4Announcements)
+	assert.Equal(t, 1, statistics.IPv4Withdrawals)
+	assert.Equal(t, 1, statistics.FailedFiles)
+	assert.Equal(t, 1, statistics.UnknownFormatFiles)
+	assert.Equal(t, map[string]int{FilterReasonReservedASN: 3}, statistics.Filtered)
+	assert.Equal(t, int64(300), statistics.DumpSkew)
+	assert.Len(t, statistics.Files, 3)
+
+	// a peer is counted once per prefix, a collector once per prefix, regardless of how many of its peers received it
+	assert.ElementsMatch(t, []PeerStatistics{
+		{Peer: rrc00, PrefixStatistics: PrefixStatistics{IPv4Prefixes: 2, IPv4MOASPrefixes: 1, IPv6Prefixes: 1}},
+		{Peer: other, PrefixStatistics: PrefixStatistics{IPv4Prefixes: 1, IPv4MOASPrefixes: 1}, SessionResets: 1},
+		{Peer: rrc01, PrefixStatistics: PrefixStatistics{IPv4Prefixes: 1, IPv4MOASPrefixes: 1}},
+	}, statistics.Peers)
+	assert.Equal(t, []CollectorStatistics{
+		{Collector: "rrc00", Peers: 2, PrefixStatistics: PrefixStatistics{IPv4Prefixes: 2, IPv4MOASPrefixes: 1, IPv6Prefixes: 1}, SessionResets: 1},
+		{Collector: "rrc01", Peers: 1, PrefixStatistics: PrefixStatistics{IPv4Prefixes: 1, IPv4MOASPrefixes: 1}},
+	}, statistics.Collectors)
+}
+
+// readJSON decodes an output file of PrintMOASPrefixes.
+func readJSON(t *testing.T, directory, name string, v interface{}) {
+	data, err := os.ReadFile(filepath.Join(directory, name))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+}
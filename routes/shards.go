@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"github.com/TheFireMike/moasDetector/rir"
+	"sync"
+)
+
+// batchSize is the number of announcements which are sent to a shard at once.
+const batchSize = 1024
+
+// shardBuffer is the number of batches which can be queued per shard before the producers block.
+const shardBuffer = 16
+
+// Batcher collects announcements and sends them in batches to the shards of a view. Announcements for the same prefix
+// always go to the same shard, so their order is kept. A Batcher must not be used concurrently and has to be flushed
+// after the last announcement.
+type Batcher struct {
+	shards  []chan []RouteAnnouncement
+	batches [][]RouteAnnouncement
+}
+
+func NewBatcher(shards []chan []RouteAnnouncement) *Batcher {
+	return &Batcher{
+		shards:  shards,
+		batches: make([][]RouteAnnouncement, len(shards)),
+	}
+}
+
+// Add adds the announcement to the batch of its shard. SessionDown announcements affect all prefixes and are therefore
+// added to the batches of all shards.
+func (b *Batcher) Add(announcement RouteAnnouncement) {
+	if announcement.Type == SessionDown {
+		for shard := range b.shards {
+			b.add(shard, announcement)
+		}
+		return
+	}
+	b.add(shardIndex(announcement.Prefix, len(b.shards)), announcement)
+}
+
+func (b *Batcher) add(shard int, announcement RouteAnnouncement) {
+	if b.batches[shard] == nil {
+		b.batches[shard] = make([]RouteAnnouncement, 0, batchSize)
+	}
+	b.batches[shard] = append(b.batches[shard], announcement)
+	if len(b.batches[shard]) == batchSize {
+		b.send(shard)
+	}
+}
+
+func (b *Batcher) send(shard int) {
+	b.shards[shard] <- b.batches[shard]
+	b.batches[shard] = nil
+}
+
+// Flush sends all incomplete batches.
+func (b *Batcher) Flush() {
+	for shard, batch := range b.batches {
+		if len(batch) > 0 {
+			b.send(shard)
+		}
+	}
+}
+
+// shardIndex maps the prefix to one of the shards using the FNV-1a hash.
+func shardIndex(prefix string, shards int) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(prefix); i++ {
+		hash ^= uint32(prefix[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(shards))
+}
+
+// routeView contains the routes of one address family and SAFI. The prefixes are distributed over shards which are
+// handled concurrently, as every prefix is only contained in one shard, the results of the shards can simply be merged.
+type routeView []*routeData
+
+func newRouteView(replay bool, shards int) routeView {
+	view := make(routeView, shards)
+	for i := range view {
+		view[i] = newRouteData(replay)
+	}
+	return view
+}
+
+func (v routeView) handleAnnouncements(shards []chan []RouteAnnouncement, wg *sync.WaitGroup) {
+	for i, shard := range shards {
+		wg.Add(1)
+		go v[i].handleAnnouncements(shard, wg)
+	}
+}
+
+func (v routeView) getMOASPrefixes() []MOASPrefix {
+	var moas []MOASPrefix
+	for _, shard := range v {
+		moas = append(moas, shard.getMOASPrefixes()...)
+	}
+	return moas
+}
+
+func (v routeView) getUnallocatedRoutes(delegations *rir.Delegations) []UnallocatedRoute {
+	var unallocated []UnallocatedRoute
+	for _, shard := range v {
+		unallocated = append(unallocated, shard.getUnallocatedRoutes(delegations)...)
+	}
+	return unallocated
+}
+
+func (v routeView) getMOASEvents() []MOASEvent {
+	var events []MOASEvent
+	for _, shard := range v {
+		events = append(events, shard.getMOASEvents()...)
+	}
+	return events
+}
+
+func (v routeView) prefixes() int {
+	var prefixes int
+	for _, shard := range v {
+		prefixes += len(shard.prefixes)
+	}
+	return prefixes
+}
+
+func (v routeView) announcements() int {
+	var announcements int
+	for _, shard := range v {
+		announcements += shard.announcements
+	}
+	return announcements
+}
+
+func (v routeView) withdrawals() int {
+	var withdrawals int
+	for _, shard := range v {
+		withdrawals += shard.withdrawals
+	}
+	return withdrawals
+}
+
+func (v routeView) countPrefixes(peerStatistics map[Peer]*PeerStatistics, collectorStatistics map[string]*CollectorStatistics, moas []MOASPrefix, count func(prefixStatistic *PrefixStatistics, isMOAS bool)) {
+	moasLookup := make(map[string]struct{})
+	for _, prefix := range moas {
+		moasLookup[prefix.Prefix] = struct{}{}
+	}
+
+	for _, shard := range v {
+		shard.countPrefixes(peerStatistics, collectorStatistics, moasLookup, count)
+	}
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouteView_ShardedResultsAreIdentical(t *testing.T) {
+	announcements := generateAnnouncements(20000)
+
+	expected := buildRouteView(announcements, true, 1)
+	actual := buildRouteView(announcements, true, 8)
+
+	assert.Equal(t, expected.prefixes(), actual.prefixes())
+	assert.Equal(t, expected.announcements(), actual.announcements())
+	assert.Equal(t, expected.withdrawals(), actual.withdrawals())
+	assert.Equal(t, sortedMOASPrefixes(expected.getMOASPrefixes()), sortedMOASPrefixes(actual.getMOASPrefixes()))
+
+	expectedEvents := expected.getMOASEvents()
+	actualEvents := actual.getMOASEvents()
+	sortMOASEvents(expectedEvents)
+	sortMOASEvents(actualEvents)
+	assert.NotEmpty(t, expectedEvents)
+	assert.Equal(t, expectedEvents, actualEvents)
+}
+
+func BenchmarkRouteView(b *testing.B) {
+	announcements := generateAnnouncements(200000)
+
+	for _, shards := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				buildRouteView(announcements, false, shards)
+			}
+		})
+	}
+}
+
+// generateAnnouncements generates announcements and withdrawals of 5 peers for 1000 prefixes with changing origins.
+// In between, the session of one peer goes down.
+func generateAnnouncements(count int) []RouteAnnouncement {
+	start := time.Unix(1600000000, 0)
+
+	var announcements []RouteAnnouncement
+	for i := 0; i < count; i++ {
+		announcement := RouteAnnouncement{
+			Type:       Announce,
+			Prefix:     fmt.Sprintf("10.%d.%d.0/24", i%1000/250, i%250),
+			OriginAS:   strconv.Itoa(64496 + i%7%3),
+			ReceivedBy: Peer{AS: strconv.Itoa(i / 1000 % 5), IP: fmt.Sprintf("192.0.2.%d", i/1000%5)},
+			Timestamp:  start.Add(time.Duration(i) * time.Second),
+		}
+		switch {
+		case i == count/2:
+			announcement = RouteAnnouncement{
+				Type:       SessionDown,
+				ReceivedBy: announcement.ReceivedBy,
+				Timestamp:  announcement.Timestamp,
+			}
+		case i%11 == 0:
+			announcement.Type = Withdraw
+			announcement.OriginAS = ""
+		}
+		announcements = append(announcements, announcement)
+	}
+	return announcements
+}
+
+func buildRouteView(announcements []RouteAnnouncement, replay bool, shards int) routeView {
+	channels := newShardChannels(shards)
+	view := newRouteView(replay, shards)
+
+	wg := sync.WaitGroup{}
+	view.handleAnnouncements(channels, &wg)
+
+	batcher := NewBatcher(channels)
+	for _, announcement := range announcements {
+		batcher.Add(announcement)
+	}
+	batcher.Flush()
+	for _, channel := range channels {
+		close(channel)
+	}
+	wg.Wait()
+
+	return view
+}
+
+func sortedMOASPrefixes(moas []MOASPrefix) []MOASPrefix {
+	for _, prefix := range moas {
+		sort.Slice(prefix.Origin, func(i, j int) bool {
+			return prefix.Origin[i].AS < prefix.Origin[j].AS
+		})
+	}
+	sort.Slice(moas, func(i, j int) bool {
+		return moas[i].Prefix < moas[j].Prefix
+	})
+	return moas
+}